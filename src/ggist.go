@@ -21,16 +21,65 @@
 package main
 
 import (
+    "bufio"
+    "context"
     "gist"
+    "gisttest"
     "errors"
     "fmt"
     "io/ioutil"
     "os"
+    "os/signal"
+    "path"
+    "regexp"
+    "sort"
+    "strconv"
     "strings"
     "time"
     "util"
 )
 
+/* Exit codes telling apart the different kinds of failures */
+const (
+    exitSuccess         = 0
+    exitFailure         = 1
+    exitNetwork         = 2
+    exitUnauthorized    = 3
+    exitForbidden       = 4
+    exitNotFound        = 5
+    exitValidation      = 6
+    exitRateLimited     = 7
+    exitInterrupted     = 130
+)
+
+func exitCode(err error) int {
+    var networkError *gist.NetworkError
+    var unauthorizedError *gist.UnauthorizedError
+    var forbiddenError *gist.ForbiddenError
+    var notFoundError *gist.NotFoundError
+    var validationError *gist.ValidationError
+    var rateLimitError *gist.RateLimitError
+    
+    switch {
+    case errors.Is(err, context.Canceled):
+        return exitInterrupted
+    case errors.As(err, &networkError):
+        return exitNetwork
+    case errors.As(err, &unauthorizedError):
+        return exitUnauthorized
+    case errors.As(err, &forbiddenError):
+        return exitForbidden
+    case errors.As(err, &notFoundError):
+        return exitNotFound
+    case errors.As(err, &validationError):
+        return exitValidation
+    case errors.As(err, &rateLimitError):
+        return exitRateLimited
+    default:
+        return exitFailure
+    }
+}
+
 func stdinIsPipe() (bool, error) {
     stat, err := os.Stdin.Stat()
     if err != nil {
@@ -40,83 +89,1064 @@ func stdinIsPipe() (bool, error) {
     return stat.Mode() & os.ModeCharDevice == 0, nil
 }
 
-func makeSimpleGist(api *gist.GistAPI, 
-                    desc string, 
-                    public bool,
-                    fileName string) (*gist.Gist, error) {
-
+func makeSimpleGist(ctx context.Context, 
+                    backend gist.Backend, 
+                    desc string, 
+                    public bool, 
+                    fileName string) (*gist.Gist, error) {
+
+    
+    data, err := ioutil.ReadAll(os.Stdin)
+    if err != nil {
+        return nil, errors.New("ioutil.ReadAll(): " + err.Error())
+    }
+    
+    if len(data) == 0 {
+        fmt.Printf("Nothing to read on stdin - done...\n")
+        os.Exit(0)
+    }
+    
+    info := gist.SimpleGistInfo{}
+    info.Description = ensureValidDescription(desc)
+    info.Public      = public
+    info.FileName    = ensureValidFileName(fileName)
+    info.Data        = data
+    
+    gist, err := backend.CreateSimpleGistContext(ctx, &info)
+    if err != nil {
+        err = fmt.Errorf("gist.Backend.CreateSimpleGist(): %w", err)
+        return nil, err
+    }
+    
+    return gist, nil
+}
+
+func makeGist(ctx context.Context, 
+              backend gist.Backend, 
+              desc string, 
+              public bool, 
+              files *[]string) (*gist.Gist, error) {
+    info := gist.GistInfo{}
+    info.Description = ensureValidDescription(desc)    
+    info.Public      = public
+    info.Files       = *files
+    
+    return backend.CreateGistContext(ctx, &info)
+}
+
+func updateGist(ctx context.Context, 
+                backend gist.Backend, 
+                id string, 
+                desc string, 
+                files *[]string, 
+                renames []string, 
+                removes []string) (*gist.Gist, error) {
+    info := gist.GistUpdateInfo{}
+    info.Description = desc
+    info.Files       = *files
+    info.Renames     = make(map[string]string, len(renames))
+    info.Deletes     = removes
+    
+    for _, x := range renames {
+        names := strings.SplitN(x, "=", 2)
+        
+        if len(names) != 2 || len(names[0]) == 0 || len(names[1]) == 0 {
+            return nil, errors.New("Invalid rename \"" + x + "\": use old=new")
+        }
+        
+        info.Renames[names[0]] = names[1]
+    }
+    
+    return backend.UpdateGistContext(ctx, id, &info)
+}
+
+/*
+ * History indices are written as "#i". Plain small numbers are taken as
+ * indices too, unless the backend uses numeric ids itself. Everything
+ * else is passed on as gist id or url.
+ */
+func resolveGistId(s string, 
+                   backend gist.Backend, 
+                   history *gist.History) (string, error) {
+    _, numericIds := backend.(*gist.GitLab)
+    
+    i, err := strconv.Atoi(strings.TrimPrefix(s, "#"))
+    if err != nil {
+        return backend.ParseGistId(s), nil
+    }
+    
+    if !strings.HasPrefix(s, "#") && (numericIds || i > history.Len()) {
+        return backend.ParseGistId(s), nil
+    }
+    
+    if i < 1 || i > history.Len() {
+        return "", errors.New(fmt.Sprintf("Invalid history index %d", i))
+    }
+    
+    return history.GetGistIdAt(i), nil
+}
+
+/*
+ * Delete all given gists and drop them from the history. Failures are
+ * reported for each gist separately. Returns the exit code matching the
+ * last failure or exitSuccess if all gists were deleted.
+ */
+func deleteGists(ctx context.Context, 
+                 backend gist.Backend, 
+                 history *gist.History, 
+                 args []string, 
+                 yes bool, 
+                 isPipe bool) int {
+    ids := make([]string, 0, len(args))
+    
+    /* Resolve all indices before the history gets modified */
+    for _, x := range args {
+        id, err := resolveGistId(x, backend, history)
+        if err != nil {
+            util.Error(err)
+            return exitFailure
+        }
+        
+        ids = append(ids, id)
+    }
+    
+    if !yes {
+        if isPipe {
+            util.Error("Unable to ask for confirmation: use --yes to delete")
+            return exitFailure
+        }
+        
+        question := fmt.Sprintf("Delete %d gist(s): %s?", 
+                                len(ids), 
+                                strings.Join(ids, ", "))
+        
+        if !askForConfirmation(question) {
+            fmt.Printf("Aborted - no gists were deleted\n")
+            return exitSuccess
+        }
+    }
+    
+    status := exitSuccess
+    
+    for _, x := range ids {
+        err := backend.DeleteGistContext(ctx, x)
+        if err != nil {
+            util.Error(fmt.Sprintf("Failed to delete gist %s: %s", x, err))
+            status = exitCode(err)
+            continue
+        }
+        
+        fmt.Printf("Deleted gist %s\n", x)
+        
+        _, err = history.RemoveGist(x)
+        if err != nil {
+            util.Warning("Failed to update history: " + err.Error())
+        }
+    }
+    
+    return status
+}
+
+/*
+ * Entries of the history name the account they were recorded for. An
+ * unknown account is no reason to fail a command.
+ */
+func setHistoryAccount(ctx context.Context, 
+                       backend gist.Backend, 
+                       history *gist.History) {
+    user, err := backend.GetAuthenticatedUserContext(ctx)
+    if err != nil {
+        util.Warning("Failed to look up your account: " + err.Error())
+        return
+    }
+    
+    history.SetAccount(user.Login)
+}
+
+/*
+ * A history which cannot be written is no reason to fail a command.
+ */
+func recordGist(history *gist.History, x *gist.Gist, action string) {
+    err := history.AddGist(x, action)
+    if err != nil {
+        util.Warning("Failed to update history: " + err.Error())
+    }
+}
+
+/*
+ * Uploads are public unless requested otherwise on the command line or
+ * by setting "visibility = secret" in the config file.
+ */
+func isPublicUpload(secret bool, 
+                    public bool, 
+                    config *util.Config) (bool, error) {
+    if secret && public {
+        return false, errors.New("--secret and --public are exclusive")
+    }
+    
+    if secret || public {
+        return public, nil
+    }
+    
+    switch config.Get("visibility") {
+    case "", "public":
+        return true, nil
+    case "secret":
+        return false, nil
+    default:
+        msg := "Invalid visibility \"" + config.Get("visibility") + "\" " +
+               "in config file: use \"public\" or \"secret\""
+        return false, errors.New(msg)
+    }
+}
+
+/*
+ * Public gists can be found by everyone. Make sure that the very first
+ * public upload happens on purpose.
+ */
+func confirmFirstPublicUpload(isPipe bool) bool {
+    util.Warning("This gist will be public and visible to everyone.")
+    
+    if isPipe {
+        msg := "Unable to ask for confirmation: " +
+               "use --public, --secret or --yes to upload"
+        util.Error(msg)
+        return false
+    }
+    
+    return askForConfirmation("Upload public gist?")
+}
+
+func askForConfirmation(question string) bool {
+    fmt.Printf("%s [y/N] ", question)
+    
+    line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+    if err != nil {
+        return false
+    }
+    
+    answer := strings.ToLower(strings.TrimSpace(line))
+    
+    return answer == "y" || answer == "yes"
+}
+
+func ensureValidDescription(desc string) string {
+    if len(desc) > 0 {
+        return desc
+    }
+    now := time.Now().Format(time.RFC850)
+    
+    return fmt.Sprintf("ggist upload dated from %s", now)
+}
+
+func ensureValidFileName(fileName string) string {
+    if len(fileName) > 0 {
+        return fileName
+    }
+    
+    return "unknown"
+}
+
+/*
+ * The token passed on the command line takes precedence over the
+ * environment which in turn takes precedence over the config file.
+ */
+func lookupToken(token string, config *util.Config) string {
+    if len(token) > 0 {
+        return token
+    }
+    
+    token = os.Getenv("GGIST_TOKEN")
+    if len(token) > 0 {
+        return token
+    }
+    
+    return config.Get("token")
+}
+
+/*
+ * Accept either a duration like "2h" which is interpreted relative to now,
+ * or an absolute time given as RFC 3339 or plain date.
+ */
+func parseTime(s string) (time.Time, error) {
+    if len(s) == 0 {
+        return time.Time{}, nil
+    }
+    
+    d, err := time.ParseDuration(s)
+    if err == nil {
+        return time.Now().Add(-d), nil
+    }
+    
+    for _, x := range []string{ time.RFC3339, "2006-01-02" } {
+        t, err := time.ParseInLocation(x, s, time.Local)
+        if err == nil {
+            return t, nil
+        }
+    }
+    
+    return time.Time{}, errors.New("Invalid time \"" + s + "\"")
+}
+
+func parseTimeout(s string) (time.Duration, error) {
+    if len(s) == 0 {
+        return 0, nil
+    }
+    
+    d, err := time.ParseDuration(s)
+    if err != nil || d < 0 {
+        return 0, errors.New("Invalid timeout \"" + s + "\"")
+    }
+    
+    return d, nil
+}
+
+/*
+ * Cached responses are kept in $XDG_CACHE_HOME/ggist which defaults to
+ * ~/.cache/ggist.
+ */
+func cacheDir(home string) string {
+    dir := os.Getenv("XDG_CACHE_HOME")
+    if len(dir) == 0 {
+        dir = home + "/.cache"
+    }
+    
+    return dir + "/ggist"
+}
+
+/*
+ * Return an error naming the first used option which is only supported
+ * by GitHub.
+ */
+func checkGitHubOnly(used map[string]bool) error {
+    names := make([]string, 0, len(used))
+    
+    for key, val := range used {
+        if val {
+            names = append(names, key)
+        }
+    }
+    
+    if len(names) == 0 {
+        return nil
+    }
+    
+    sort.Strings(names)
+    
+    return fmt.Errorf("--%s: %w", names[0], gist.ErrNotSupported)
+}
+
+/*
+ * The local backend keeps its gists in $XDG_DATA_HOME/ggist which
+ * defaults to ~/.local/share/ggist.
+ */
+func dataDir(home string) string {
+    dir := os.Getenv("XDG_DATA_HOME")
+    if len(dir) == 0 {
+        dir = home + "/.local/share"
+    }
+    
+    return dir + "/ggist"
+}
+
+func lookupSetting(val string, config *util.Config, key string) string {
+    if len(val) > 0 {
+        return val
+    }
+    
+    return config.Get(key)
+}
+
+func checkFiles(files []string) ([]string, error) {
+    checked := make([]string, 0, len(files))
+    
+    for _, x := range files {
+        stat, err := os.Stat(x)
+        
+        switch {
+        case err != nil:
+            return nil, err
+        case !stat.Mode().IsRegular():
+            return nil, errors.New(x + " is not a regular file")
+        default:
+            checked = append(checked, x)
+        }
+    }
+    
+    return checked, nil
+}
+
+func newIndexTask(ctx context.Context, 
+                  backend gist.Backend, 
+                  history *gist.History, 
+                  i int, 
+                  lines bool, 
+                  comments bool) util.Task {
+    if i < 1 || i > history.Len() {
+        return func() (func(), error) {
+            return nil, errors.New(fmt.Sprintf("Invalid history index %d", i))
+        }
+    }
+    
+    id := history.GetGistIdAt(i)
+    
+    task := newGetTask(ctx, backend, history, id, lines, comments)
+    
+    return func() (func(), error) {
+        done, err := task()
+        if err != nil {
+            return nil, fmt.Errorf("History index %d: %w", i, err)
+        }
+        
+        return done, nil
+    }
+}
+
+func newGetTask(ctx context.Context, 
+                backend gist.Backend, 
+                history *gist.History, 
+                s string, 
+                lines bool, 
+                comments bool) util.Task {
+    return func() (func(), error) {
+        received, err := getGist(ctx, backend, s)
+        if err != nil {
+            return nil, fmt.Errorf("Gist %s: %w", s, err)
+        }
+        
+        list := []gist.Comment{}
+        
+        /* main() rejects --comments for backends other than GitHub */
+        if comments {
+            api := backend.(*gist.GistAPI)
+            
+            list, err = api.ListCommentsContext(ctx, received.Id, nil)
+            if err != nil {
+                return nil, fmt.Errorf("Comments of gist %s: %w", s, err)
+            }
+        }
+        
+        return func() {
+            recordGist(history, received, gist.ActionFetched)
+            
+            printReceivedGist(received, lines)
+            
+            if comments {
+                printComments(list)
+            }
+        }, nil
+    }
+}
+
+/*
+ * Listings are printed while they are received instead of being collected
+ * by a task, so large accounts neither stall the output nor fill memory.
+ */
+func printUsersGists(ctx context.Context, 
+                     backend gist.Backend, 
+                     user string, 
+                     opts *gist.ListOptions) error {
+    fn := func(x *gist.Gist) error {
+        fmt.Printf("Gist Id: %s\n", x.Id)
+        
+        return nil
+    }
+    
+    err := backend.ForEachUsersGistContext(ctx, user, opts, fn)
+    if err != nil {
+        return fmt.Errorf("User %s: %w", user, err)
+    }
+    
+    return nil
+}
+
+/*
+ * Fetch a gist given as "<id>" or a specific revision of it given as
+ * "<id>@<sha>".
+ */
+func getGist(ctx context.Context, 
+             backend gist.Backend, 
+             s string) (*gist.Gist, error) {
+    index := strings.LastIndex(s, "@")
+    if index < 0 {
+        return backend.GetGistContext(ctx, s)
+    }
+    
+    api, ok := backend.(*gist.GistAPI)
+    if !ok {
+        return nil, gist.ErrNotSupported
+    }
+    
+    return api.GetGistRevisionContext(ctx, s[:index], s[index + 1:])
+}
+
+func printRevisions(ctx context.Context, 
+                    api *gist.GistAPI, 
+                    history *gist.History, 
+                    s string, 
+                    opts *gist.ListOptions) error {
+    id, err := resolveGistId(s, api, history)
+    if err != nil {
+        return err
+    }
+    
+    fmt.Printf("Revisions of gist %s:\n", id)
+    
+    fn := func(x *gist.GistRevision) error {
+        user := "unknown"
+        if x.User != nil {
+            user = x.User.Login
+        }
+        
+        fmt.Printf("  %s  %s  %-20s +%d -%d\n",
+                   x.Version,
+                   x.CommittedAt.Local().Format("2006-01-02 15:04:05"),
+                   user,
+                   x.ChangeStatus.Additions,
+                   x.ChangeStatus.Deletions)
+        
+        return nil
+    }
+    
+    return api.ForEachRevisionContext(ctx, id, opts, fn)
+}
+
+/*
+ * Print the differences between two revisions of a gist. The arguments
+ * are the gist, the old revision and optionally the new revision which
+ * defaults to the current state of the gist.
+ */
+func printRevisionDiff(ctx context.Context, 
+                       api *gist.GistAPI, 
+                       history *gist.History, 
+                       args []string) error {
+    if len(args) < 2 || len(args) > 3 {
+        return errors.New("--diff expects <id> <old-rev> [<new-rev>]")
+    }
+    
+    id, err := resolveGistId(args[0], api, history)
+    if err != nil {
+        return err
+    }
+    
+    oldGist, err := api.GetGistRevisionContext(ctx, id, args[1])
+    if err != nil {
+        return err
+    }
+    
+    var newGist *gist.Gist
+    
+    newRev := "current"
+    
+    if len(args) == 3 {
+        newRev = args[2]
+        newGist, err = api.GetGistRevisionContext(ctx, id, newRev)
+    } else {
+        newGist, err = api.GetGistContext(ctx, id)
+    }
+    
+    if err != nil {
+        return err
+    }
+    
+    names := make([]string, 0, len(oldGist.Files) + len(newGist.Files))
+    
+    for key, _ := range oldGist.Files {
+        names = append(names, key)
+    }
+    
+    for key, _ := range newGist.Files {
+        if _, ok := oldGist.Files[key]; !ok {
+            names = append(names, key)
+        }
+    }
+    
+    sort.Strings(names)
+    
+    changed := false
+    
+    for _, x := range names {
+        oldContent, newContent := "", ""
+        
+        if file, ok := oldGist.Files[x]; ok {
+            oldContent = file.Content
+        }
+        
+        if file, ok := newGist.Files[x]; ok {
+            newContent = file.Content
+        }
+        
+        diff := util.UnifiedDiff("a/" + x + "@" + args[1], 
+                                 "b/" + x + "@" + newRev, 
+                                 oldContent, 
+                                 newContent, 
+                                 3)
+        
+        if len(diff) > 0 {
+            fmt.Printf("%s", diff)
+            changed = true
+        }
+    }
+    
+    if !changed {
+        fmt.Printf("No differences between %s and %s\n", args[1], newRev)
+    }
+    
+    return nil
+}
+
+func forkGist(ctx context.Context, 
+              api *gist.GistAPI, 
+              history *gist.History, 
+              s string, 
+              verbose bool) error {
+    id, err := resolveGistId(s, api, history)
+    if err != nil {
+        return err
+    }
+    
+    fork, err := api.ForkContext(ctx, id)
+    if err != nil {
+        return err
+    }
+    
+    if verbose {
+        msg := "Forked gist %s into %s\n" +
+               "  Url         : %s\n" +
+               "  Description : %s\n"
+        
+        fmt.Printf(msg, id, fork.Id, fork.Url, fork.Description)
+    } else {
+        fmt.Printf("Forked gist %s into %s: %s\n", id, fork.Id, fork.Url)
+    }
+    
+    recordGist(history, fork, gist.ActionForked)
+    
+    return nil
+}
+
+func printForks(ctx context.Context, 
+                api *gist.GistAPI, 
+                history *gist.History, 
+                s string, 
+                opts *gist.ListOptions) error {
+    id, err := resolveGistId(s, api, history)
+    if err != nil {
+        return err
+    }
+    
+    fmt.Printf("Forks of gist %s:\n", id)
+    
+    return api.ForEachForkContext(ctx, id, opts, func(x *gist.Gist) error {
+        owner := "unknown"
+        if x.Owner != nil {
+            owner = x.Owner.Login
+        }
+        
+        fmt.Printf("  %s  %-20s %s\n", x.Id, owner, x.Url)
+        
+        return nil
+    })
+}
+
+func printComments(comments []gist.Comment) {
+    msg := "\n" +
+           "Comment  : %d\n" +
+           "User     : %s\n" +
+           "Date     : %s\n" +
+           "------------------------------------------------------------------\n" +
+           "%s\n"
+    
+    for _, x := range comments {
+        user := "unknown"
+        if x.User != nil {
+            user = x.User.Login
+        }
+        
+        date := x.CreatedAt.Local().Format(time.RFC1123)
+        
+        fmt.Printf(msg, x.Id, user, date, x.Body)
+    }
+}
+
+func readCommentBody(message string, isPipe bool) (string, error) {
+    if len(message) > 0 {
+        return message, nil
+    }
+    
+    if !isPipe {
+        return "", errors.New("No comment given: use --message or stdin")
+    }
+    
+    data, err := ioutil.ReadAll(os.Stdin)
+    if err != nil {
+        return "", errors.New("ioutil.ReadAll(): " + err.Error())
+    }
+    
+    body := strings.TrimSpace(string(data))
+    if len(body) == 0 {
+        return "", errors.New("No comment given: stdin is empty")
+    }
+    
+    return body, nil
+}
+
+func postComment(ctx context.Context, 
+                 api *gist.GistAPI, 
+                 history *gist.History, 
+                 s string, 
+                 message string, 
+                 isPipe bool) error {
+    id, err := resolveGistId(s, api, history)
+    if err != nil {
+        return err
+    }
+    
+    body, err := readCommentBody(message, isPipe)
+    if err != nil {
+        return err
+    }
+    
+    comment, err := api.CreateCommentContext(ctx, id, body)
+    if err != nil {
+        return err
+    }
+    
+    fmt.Printf("Created comment %d on gist %s\n", comment.Id, id)
+    
+    return nil
+}
+
+func editGistComment(ctx context.Context, 
+                     api *gist.GistAPI, 
+                     history *gist.History, 
+                     args []string, 
+                     message string, 
+                     isPipe bool) error {
+    if len(args) != 2 {
+        return errors.New("--edit-comment expects <gist> <comment-id>")
+    }
+    
+    id, err := resolveGistId(args[0], api, history)
+    if err != nil {
+        return err
+    }
+    
+    commentId, err := strconv.ParseInt(args[1], 10, 64)
+    if err != nil {
+        return errors.New("Invalid comment id " + args[1])
+    }
+    
+    body, err := readCommentBody(message, isPipe)
+    if err != nil {
+        return err
+    }
+    
+    _, err = api.UpdateCommentContext(ctx, id, commentId, body)
+    if err != nil {
+        return err
+    }
+    
+    fmt.Printf("Updated comment %d on gist %s\n", commentId, id)
+    
+    return nil
+}
+
+func deleteGistComments(ctx context.Context, 
+                        api *gist.GistAPI, 
+                        history *gist.History, 
+                        args []string) error {
+    if len(args) < 2 {
+        return errors.New("--delete-comment expects <gist> <comment-id>...")
+    }
+    
+    id, err := resolveGistId(args[0], api, history)
+    if err != nil {
+        return err
+    }
+    
+    for _, x := range args[1:] {
+        commentId, err := strconv.ParseInt(x, 10, 64)
+        if err != nil {
+            return errors.New("Invalid comment id " + x)
+        }
+        
+        err = api.DeleteCommentContext(ctx, id, commentId)
+        if err != nil {
+            return err
+        }
+        
+        fmt.Printf("Deleted comment %d on gist %s\n", commentId, id)
+    }
+    
+    return nil
+}
+
+func starGist(ctx context.Context, 
+              api *gist.GistAPI, 
+              history *gist.History, 
+              s string, 
+              star bool) error {
+    id, err := resolveGistId(s, api, history)
+    if err != nil {
+        return err
+    }
+    
+    if star {
+        err = api.StarContext(ctx, id)
+    } else {
+        err = api.UnstarContext(ctx, id)
+    }
+    
+    if err != nil {
+        return err
+    }
+    
+    if star {
+        fmt.Printf("Starred gist %s\n", id)
+    } else {
+        fmt.Printf("Unstarred gist %s\n", id)
+    }
+    
+    return nil
+}
+
+func printIsStarred(ctx context.Context, 
+                    api *gist.GistAPI, 
+                    history *gist.History, 
+                    s string) error {
+    id, err := resolveGistId(s, api, history)
+    if err != nil {
+        return err
+    }
+    
+    starred, err := api.IsStarredContext(ctx, id)
+    if err != nil {
+        return err
+    }
+    
+    if starred {
+        fmt.Printf("Gist %s is starred\n", id)
+    } else {
+        fmt.Printf("Gist %s is not starred\n", id)
+    }
+    
+    return nil
+}
+
+func printMyGists(ctx context.Context, 
+                  backend gist.Backend, 
+                  opts *gist.ListOptions) error {
+    return printGistTable(func(fn func(*gist.Gist) error) error {
+        return backend.ForEachMyGistContext(ctx, opts, fn)
+    }, nil)
+}
+
+/*
+ * The public feed is only examined this far if neither --since nor --max
+ * limit it. GitHub serves thousands of gists otherwise.
+ */
+const defaultFeedWindow = 30
+
+/*
+ * Stream the public gists matching the given language and file name
+ * pattern. Empty filters match every gist.
+ */
+func printPublicFeed(ctx context.Context, 
+                     api *gist.GistAPI, 
+                     opts *gist.ListOptions, 
+                     language string, 
+                     pattern string) error {
+    _, err := path.Match(pattern, "")
+    if err != nil {
+        return errors.New("Invalid file name pattern \"" + pattern + "\"")
+    }
+    
+    filter := func(x *gist.Gist) bool {
+        for key, val := range x.Files {
+            if len(language) > 0 && !strings.EqualFold(val.Language, language) {
+                continue
+            }
+            
+            if len(pattern) > 0 {
+                if ok, _ := path.Match(pattern, key); !ok {
+                    continue
+                }
+            }
+            
+            return true
+        }
+        
+        return false
+    }
+    
+    /* The limit applies to the matching gists, not to the listed ones */
+    feedOpts := &gist.ListOptions{PerPage : opts.PerPage, Since : opts.Since}
+    count := 0
+    
+    if opts.Max <= 0 && opts.Since.IsZero() {
+        feedOpts.Max = defaultFeedWindow
+        
+        if opts.PerPage > 0 {
+            feedOpts.Max = opts.PerPage
+        }
+    }
+    
+    return printGistTable(func(fn func(*gist.Gist) error) error {
+        limited := func(x *gist.Gist) error {
+            if !filter(x) {
+                return nil
+            }
+            
+            err := fn(x)
+            if err != nil {
+                return err
+            }
+            
+            count += 1
+            if opts.Max > 0 && count >= opts.Max {
+                return gist.ErrStop
+            }
+            
+            return nil
+        }
+        
+        return api.ForEachPublicContext(ctx, feedOpts, limited)
+    }, nil)
+}
+
+/*
+ * Print one row for each gist passed by forEach to its callback and
+ * accepted by filter. A nil filter accepts every gist.
+ */
+func printGistTable(forEach func(func(*gist.Gist) error) error, 
+                    filter func(*gist.Gist) bool) error {
+    format := "%-32s  %-6s  %-16s  %-30s  %s\n"
+    header := true
+    
+    return forEach(func(x *gist.Gist) error {
+        if filter != nil && !filter(x) {
+            return nil
+        }
+        
+        if header {
+            fmt.Printf(format, 
+                       "ID", "ACCESS", "UPDATED", "FILES", "DESCRIPTION")
+            header = false
+        }
+        
+        visibility := "secret"
+        if x.Public {
+            visibility = "public"
+        }
+        
+        names := make([]string, 0, len(x.Files))
+        
+        for key, _ := range x.Files {
+            names = append(names, key)
+        }
+        
+        sort.Strings(names)
+        
+        fmt.Printf(format, 
+                   x.Id, 
+                   visibility, 
+                   x.UpdatedAt.Local().Format("2006-01-02 15:04"),
+                   strings.Join(names, ", "),
+                   x.Description)
+        
+        return nil
+    })
+}
+
+func printStarredGists(ctx context.Context, 
+                       api *gist.GistAPI, 
+                       opts *gist.ListOptions) error {
+    return api.ForEachStarredContext(ctx, opts, func(x *gist.Gist) error {
+        fmt.Printf("Gist Id: %s  %s\n", x.Id, x.Description)
+        
+        return nil
+    })
+}
+
+/*
+ * Run an in-memory gist API until ctx is cancelled. Requests with token
+ * are made on behalf of the user "octocat".
+ */
+func serveFakeApi(ctx context.Context, addr string, token string) error {
+    server := gisttest.NewServer()
     
-    data, err := ioutil.ReadAll(os.Stdin)
+    if len(token) > 0 {
+        server.AddUser(token, "octocat")
+    }
+    
+    err := server.Start(addr)
     if err != nil {
-        return nil, errors.New("ioutil.ReadAll(): " + err.Error())
+        return err
     }
     
-    if len(data) == 0 {
-        fmt.Printf("Nothing to read on stdin - done...\n")
-        os.Exit(0)
+    fmt.Printf("Serving fake gist API on %s - press Ctrl-C to stop\n", 
+               server.Url())
+    
+    <-ctx.Done()
+    
+    return server.Close()
+}
+
+/*
+ * Queries enclosed in slashes like "/^conf/" are regular expressions,
+ * everything else matches case-insensitive substrings.
+ */
+func newHistoryPattern(query string) (*regexp.Regexp, error) {
+    if len(query) == 0 {
+        return nil, nil
     }
     
-    info := gist.SimpleGistInfo{}
-    info.Description = ensureValidDescription(desc)
-    info.Public      = public
-    info.FileName    = ensureValidFileName(fileName)
-    info.Data        = data
+    expr := "(?i)" + regexp.QuoteMeta(query)
+    
+    if len(query) > 2 && query[0] == '/' && query[len(query) - 1] == '/' {
+        expr = query[1:len(query) - 1]
+    }
     
-    gist, err := api.CreateSimpleGist(&info)
+    pattern, err := regexp.Compile(expr)
     if err != nil {
-        err = errors.New("gist.GistAPI.createSimpleGist(): " + err.Error())
-        return nil, err
+        return nil, errors.New("Invalid search \"" + query + "\": " + 
+                               err.Error())
     }
     
-    return gist, nil
+    return pattern, nil
 }
 
-func makeGist(api *gist.GistAPI, 
-              desc string, 
-              public bool, 
-              files *[]string) (*gist.Gist, error) {
-    info := gist.GistInfo{}
-    info.Description = ensureValidDescription(desc)    
-    info.Public      = public
-    info.Files       = *files
+/*
+ * The printed indices can be passed to --index.
+ */
+func printHistory(history *gist.History, filter *gist.HistoryFilter) {
+    fmt.Printf("Gist History:\n")
     
-    return api.CreateGist(&info)
-}
-
-func ensureValidDescription(desc string) string {
-    if len(desc) > 0 {
-        return desc
+    for _, x := range history.Search(filter) {
+        fmt.Printf("%s\n", x.String())
     }
-    now := time.Now().Format(time.RFC850)
-    
-    return fmt.Sprintf("ggist upload dated from %s", now)
 }
 
-func ensureValidFileName(fileName string) string {
-    if len(fileName) > 0 {
-        return fileName
+func printRateLimit(ctx context.Context, api *gist.GistAPI) error {
+    rateLimit, err := api.GetRateLimitContext(ctx)
+    if err != nil {
+        return err
     }
     
-    return "unknown"
-}
-
-func checkFiles(files []string) ([]string, error) {
-    checked := make([]string, 0, len(files))
+    msg := "Rate limit:\n" +
+           "  Limit     : %d\n" +
+           "  Used      : %d\n" +
+           "  Remaining : %d\n" +
+           "  Reset     : %s\n"
     
-    for _, x := range files {
-        stat, err := os.Stat(x)
-        
-        switch {
-        case err != nil:
-            return nil, err
-        case !stat.Mode().IsRegular():
-            return nil, errors.New(x + " is not a regular file")
-        default:
-            checked = append(checked, x)
-        }
-    }
+    fmt.Printf(msg, 
+               rateLimit.Limit, 
+               rateLimit.Used, 
+               rateLimit.Remaining, 
+               rateLimit.Reset.Format(time.RFC1123))
     
-    return checked, nil
+    return nil
 }
 
 func printReceivedGist(gist *gist.Gist, lines bool) {
@@ -126,6 +1156,23 @@ func printReceivedGist(gist *gist.Gist, lines bool) {
             "Description : %s\n"
             
     fmt.Printf(msg, gist.Id, gist.Url, gist.Description)
+    
+    if gist.Owner != nil {
+        fmt.Printf("Owner       : %s\n", gist.Owner.Login)
+    }
+    
+    if !gist.CreatedAt.IsZero() {
+        msg = "Created     : %s\n" +
+              "Updated     : %s\n" +
+              "Comments    : %d\n" +
+              "Revisions   : %d\n"
+        
+        fmt.Printf(msg, 
+                   gist.CreatedAt.Local().Format(time.RFC1123), 
+                   gist.UpdatedAt.Local().Format(time.RFC1123),
+                   gist.Comments,
+                   len(gist.History))
+    }
 
     msg = "\n" + 
           "File     : %s\n" +
@@ -142,6 +1189,29 @@ func printReceivedGist(gist *gist.Gist, lines bool) {
         } else {
             fmt.Printf("%s\n", val.Content)
         }
+        
+        if val.Truncated {
+            msg := fmt.Sprintf("%s is truncated: showing %d of %d bytes", 
+                               key, len(val.Content), val.Size)
+            util.Warning(msg)
+        }
+    }
+}
+
+func printUpdatedGist(gist *gist.Gist, verbose bool) {
+    if verbose {
+        msg := "Updated gist %s\n" +
+               "  Url         : %s\n" +
+               "  Description : %s\n" +
+               "  Files       :\n"
+        
+        fmt.Printf(msg, gist.Id, gist.Url, gist.Description)
+        
+        for key, _ := range gist.Files {
+            fmt.Printf("    %s\n", key)
+        }
+    } else {
+        fmt.Printf("Updated gist %s: %s\n", gist.Id, gist.Url)
     }
 }
 
@@ -150,7 +1220,7 @@ func printUploadedGist(gist *gist.Gist, verbose bool) {
     if verbose {
         msg := "Created gist %s\n" +
                "  Url         : %s\n" +
-               "  Public      : %s\n" +
+               "  Public      : %t\n" +
                "  Description : %s\n" +
                "  Files       :\n"
         
@@ -175,6 +1245,51 @@ func main() {
     descHist        := "Print your gist history"
     descVerb        := "Print more information about gists if possible."
     descUsers       := "Retrieve gists from a user."
+    descToken       := "Authenticate with a personal access token."
+    descApiUrl      := "Set the base url of the gist API."
+    descHtmlUrl     := "Set the base url of the gist web pages."
+    descUpdate      := "Update the gist with the given id or history index #i."
+    descRename      := "Rename files of an updated gist: old=new."
+    descRemove      := "Remove files from an updated gist."
+    descDelete      := "Delete gists by id, url or history index #i."
+    descYes         := "Do not ask for confirmation."
+    descSecret      := "Upload a secret gist."
+    descPublic      := "Upload a public gist."
+    descPerPage     := "Number of gists requested per page in listings."
+    descMax         := "Maximum number of gists shown in listings."
+    descRateLimit   := "Print the current API rate limit."
+    descNoWait      := "Fail instead of waiting for an exhausted rate limit."
+    descMaxSize     := "Do not download complete files larger than n bytes."
+    descRevisions   := "List the revisions of gists."
+    descDiff        := "Show changes of a gist: <id> <old-rev> [<new-rev>]."
+    descStar        := "Star gists."
+    descUnstar      := "Unstar gists."
+    descIsStarred   := "Check whether gists are starred."
+    descStarred     := "List your starred gists."
+    descFork        := "Fork gists by id, url or history index #i."
+    descForks       := "List the forks of gists."
+    descComments    := "Print the comments of downloaded gists."
+    descSince       := "List gists updated since a date or duration, e.g. 2h."
+    descMine        := "List your own gists including secret ones."
+    descPublicFeed  := "List the latest public gists; see --since and --max."
+    descLanguage    := "Only list gists containing files of this language."
+    descPattern     := "Only list gists containing matching file names."
+    descJobs        := "Number of gists downloaded in parallel."
+    descTimeout     := "Abort requests taking longer than this, e.g. 30s."
+    descNoCache     := "Do not use the response cache."
+    descPurgeCache  := "Remove all cached responses."
+    descBackend     := "Service storing the gists: github, gitlab or local."
+    descLocalRoot   := "Directory of the local backend."
+    descServeFake   := "Serve a fake gist API for testing until Ctrl-C."
+    descAddr        := "Address the fake gist API listens on."
+    descHistSearch  := "Search the history; use /regexp/ for regexps."
+    descUntil       := "Only show history entries recorded until a date."
+    descCreatedOnly := "Only show history entries of gists you created."
+    descLimit       := "Only show the last N history entries."
+    descComment     := "Comment on a gist; see --message."
+    descMessage     := "Set the text of a comment; default: read stdin."
+    descEditComm    := "Edit a comment: <gist> <comment-id>; see --message."
+    descDelComm     := "Delete comments: <gist> <comment-id>..."
     
     var desc string
     var fileName string
@@ -183,10 +1298,54 @@ func main() {
     var help bool
     var history bool
     var lineNum bool
-    var private bool
+    var secret bool
+    var public bool
     var verbose bool
     var index []int
     var users []string
+    var token string
+    var apiUrl string
+    var htmlUrl string
+    var update string
+    var renames []string
+    var removes []string
+    var deletes []string
+    var yes bool
+    var perPage int
+    var maxCount int
+    var rateLimit bool
+    var noWait bool
+    var maxSize int
+    var revisions []string
+    var diff []string
+    var stars []string
+    var unstars []string
+    var isStarred []string
+    var starred bool
+    var forks []string
+    var listForks []string
+    var showComments bool
+    var since string
+    var mine bool
+    var publicFeed bool
+    var language string
+    var pattern string
+    var jobs int = 4
+    var timeout string
+    var noCache bool
+    var purgeCache bool
+    var backendName string
+    var localRoot string
+    var serveFake bool
+    var addr string = "localhost:8080"
+    var historySearch string
+    var until string
+    var createdOnly bool
+    var limit int
+    var comment string
+    var message string
+    var editComment []string
+    var deleteComment []string
     
     home := os.Getenv("HOME")
     
@@ -201,6 +1360,12 @@ func main() {
         os.Exit(1)
     }
     
+    config, err := util.NewConfig(home + "/.config/ggist/config")
+    if err != nil {
+        util.Error(err)
+        os.Exit(1)
+    }
+    
     options := []util.Option {
         &util.OptStr    { "description,d",  descDesc,  &desc       },
         &util.OptMulStr { "files,f",        descFiles, &files      },
@@ -212,6 +1377,51 @@ func main() {
         &util.OptStr    { "file-name,n",    descName,  &fileName   },
         &util.OptBool   { "verbose,v",      descVerb,  &verbose    },
         &util.OptMulStr { "user,u",         descUsers, &users      },
+        &util.OptStr    { "token,t",        descToken, &token      },
+        &util.OptStr    { "api-url",        descApiUrl, &apiUrl    },
+        &util.OptStr    { "html-url",       descHtmlUrl, &htmlUrl  },
+        &util.OptStr    { "update,U",       descUpdate, &update    },
+        &util.OptMulStr { "rename",         descRename, &renames   },
+        &util.OptMulStr { "remove-file",    descRemove, &removes   },
+        &util.OptMulStr { "delete,D",       descDelete, &deletes   },
+        &util.OptBool   { "yes,y",          descYes,   &yes        },
+        &util.OptBool   { "secret,s",       descSecret, &secret    },
+        &util.OptBool   { "public,p",       descPublic, &public    },
+        &util.OptInt    { "per-page",       descPerPage, &perPage  },
+        &util.OptInt    { "max,m",          descMax,   &maxCount   },
+        &util.OptBool   { "rate-limit",     descRateLimit, &rateLimit },
+        &util.OptBool   { "no-wait",        descNoWait, &noWait    },
+        &util.OptInt    { "max-size",       descMaxSize, &maxSize  },
+        &util.OptMulStr { "revisions",      descRevisions, &revisions },
+        &util.OptMulStr { "diff",           descDiff,  &diff       },
+        &util.OptMulStr { "star",           descStar,  &stars      },
+        &util.OptMulStr { "unstar",         descUnstar, &unstars   },
+        &util.OptMulStr { "is-starred",     descIsStarred, &isStarred },
+        &util.OptBool   { "starred",        descStarred, &starred  },
+        &util.OptMulStr { "fork",           descFork,  &forks      },
+        &util.OptMulStr { "forks",          descForks, &listForks  },
+        &util.OptBool   { "comments,c",     descComments, &showComments },
+        &util.OptStr    { "since",          descSince, &since      },
+        &util.OptBool   { "mine",           descMine,  &mine       },
+        &util.OptBool   { "public-feed",    descPublicFeed, &publicFeed },
+        &util.OptStr    { "language",       descLanguage, &language },
+        &util.OptStr    { "file-pattern",   descPattern, &pattern  },
+        &util.OptInt    { "jobs,j",         descJobs,  &jobs       },
+        &util.OptStr    { "timeout",        descTimeout, &timeout  },
+        &util.OptBool   { "no-cache",       descNoCache, &noCache  },
+        &util.OptBool   { "purge-cache",    descPurgeCache, &purgeCache },
+        &util.OptStr    { "backend",        descBackend, &backendName },
+        &util.OptStr    { "local-root",     descLocalRoot, &localRoot },
+        &util.OptBool   { "serve-fake",     descServeFake, &serveFake },
+        &util.OptStr    { "addr",           descAddr,  &addr       },
+        &util.OptStr    { "history-search", descHistSearch, &historySearch },
+        &util.OptStr    { "until",          descUntil, &until      },
+        &util.OptBool   { "created-only",   descCreatedOnly, &createdOnly },
+        &util.OptInt    { "limit",          descLimit, &limit      },
+        &util.OptStr    { "comment",        descComment, &comment  },
+        &util.OptStr    { "message",        descMessage, &message  },
+        &util.OptMulStr { "edit-comment",   descEditComm, &editComment },
+        &util.OptMulStr { "delete-comment", descDelComm, &deleteComment },
     }
     
     no, err := util.ParseCommandLine(options, os.Args[1:])
@@ -238,69 +1448,376 @@ func main() {
         os.Exit(1)
     }
 
-    api := gist.NewGistAPI()
-    var gist *gist.Gist
+    api := gist.NewGistAPI(
+        gist.WithToken(lookupToken(token, config)),
+        gist.WithBaseUrl(lookupSetting(apiUrl, config, "api-url")),
+        gist.WithHtmlUrl(lookupSetting(htmlUrl, config, "html-url")),
+        gist.WithUserAgent(config.Get("user-agent")),
+    )
+    
+    if noWait {
+        api.SetRateLimitPolicy(gist.RateLimitFail)
+    }
+    
+    api.SetMaxFileSize(int64(maxSize))
+    
+    timeout = lookupSetting(timeout, config, "timeout")
+    
+    requestTimeout, err := parseTimeout(timeout)
+    if err != nil {
+        util.Error(err)
+        os.Exit(1)
+    }
+    
+    api.SetTimeout(requestTimeout)
+    
+    cache, err := gist.NewCache(cacheDir(home))
+    if err != nil {
+        util.Warning("Response cache disabled: " + err.Error())
+    }
+    
+    if cache != nil && !noCache {
+        api.SetCache(cache)
+    }
+    
+    backendName = lookupSetting(backendName, config, "backend")
+    
+    var backend gist.Backend = api
+    
+    switch backendName {
+    case "", "github":
+    case "gitlab":
+        gitLab := gist.NewGitLab()
+        gitLab.SetToken(lookupToken(token, config))
+        gitLab.SetApiUrl(lookupSetting(apiUrl, config, "api-url"))
+        gitLab.SetUserAgent(config.Get("user-agent"))
+        gitLab.SetTimeout(requestTimeout)
+        
+        backend = gitLab
+        api     = nil
+    case "local":
+        localRoot = lookupSetting(localRoot, config, "local-root")
+        if len(localRoot) == 0 {
+            localRoot = dataDir(home) + "/gists"
+        }
+        
+        store, err := gist.NewLocalStore(localRoot)
+        if err != nil {
+            util.Error(err)
+            os.Exit(1)
+        }
+        
+        backend = store
+        api     = nil
+    default:
+        util.Error("Unknown backend \"" + backendName + "\"")
+        os.Exit(1)
+    }
+    
+    if api == nil {
+        err = checkGitHubOnly(map[string]bool {
+            "revisions"      : len(revisions) > 0,
+            "diff"           : len(diff) > 0,
+            "fork"           : len(forks) > 0,
+            "forks"          : len(listForks) > 0,
+            "star"           : len(stars) > 0,
+            "unstar"         : len(unstars) > 0,
+            "is-starred"     : len(isStarred) > 0,
+            "starred"        : starred,
+            "comments"       : showComments,
+            "comment"        : len(comment) > 0,
+            "edit-comment"   : len(editComment) > 0,
+            "delete-comment" : len(deleteComment) > 0,
+            "public-feed"    : publicFeed,
+            "rate-limit"     : rateLimit,
+        })
+        if err != nil {
+            util.Error(err)
+            os.Exit(1)
+        }
+    }
+    
+    /* Ctrl-C cancels all requests which are still in flight */
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+    defer stop()
+    
+    if serveFake {
+        err = serveFakeApi(ctx, addr, lookupToken(token, config))
+        if err != nil {
+            util.Error(err)
+            os.Exit(1)
+        }
+        
+        os.Exit(0)
+    }
+    
+    sinceTime, err := parseTime(since)
+    if err != nil {
+        util.Error(err)
+        os.Exit(1)
+    }
+    
+    listOpts := &gist.ListOptions{
+        PerPage : perPage, 
+        Max     : maxCount, 
+        Since   : sinceTime, 
+    }
+    
+    var uploaded *gist.Gist
     
     isPipe, err := stdinIsPipe()
     if err != nil {
         util.Warning("unable to read from stdin")
     }
     
+    /* Piped input is only uploaded as gist if nothing else was requested */
+    isOtherCommand := len(update) > 0 || len(valid_files) > 0 || 
+                      len(deletes) > 0 || len(gets) > 0 || len(index) > 0 || 
+                      len(users) > 0 || len(comment) > 0 || 
+                      len(editComment) > 0 || len(deleteComment) > 0 || 
+                      len(revisions) > 0 || len(diff) > 0 || 
+                      len(forks) > 0 || len(listForks) > 0 || 
+                      len(stars) > 0 || len(unstars) > 0 || 
+                      len(isStarred) > 0 || starred || mine || publicFeed || 
+                      history || len(historySearch) > 0 || purgeCache || 
+                      rateLimit
+    
+    isStdinGist := isPipe && !isOtherCommand
+    
+    isPublic, err := isPublicUpload(secret, public, config)
+    if err != nil {
+        util.Error(err)
+        os.Exit(1)
+    }
+    
+    isUpload := len(update) == 0 && (len(valid_files) > 0 || isStdinGist)
+    
+    if isUpload && isPublic && !public && !yes && !gistHistory.HasPublicGist() {
+        if !confirmFirstPublicUpload(isPipe) {
+            os.Exit(1)
+        }
+    }
+    
+    isRecorded := isUpload || len(update) > 0 || len(index) > 0 || 
+                  len(gets) > 0 || len(forks) > 0
+    
+    /* Anonymous requests are recorded without an account */
+    if isRecorded && (len(lookupToken(token, config)) > 0 || 
+                      backendName == "local") {
+        setHistoryAccount(ctx, backend, gistHistory)
+    }
+    
     switch {
+    case len(update) > 0:
+        var id string
+        
+        id, err = resolveGistId(update, backend, gistHistory)
+        if err == nil {
+            uploaded, err = updateGist(ctx, backend, id, desc, 
+                                       &valid_files, renames, removes)
+        }
     case len(valid_files) > 0:
-        gist, err = makeGist(api, desc, !private, &valid_files)
-    case isPipe:
-        gist, err = makeSimpleGist(api, desc, !private, fileName)
+        uploaded, err = makeGist(ctx, backend, desc, isPublic, &valid_files)
+    case isStdinGist:
+        uploaded, err = makeSimpleGist(ctx, backend, desc, isPublic, 
+                                       fileName)
     }
     
     if err != nil {
         util.Error(err)
-        os.Exit(1)
-    } else if gist != nil {
-        printUploadedGist(gist, verbose)
+        os.Exit(exitCode(err))
+    } else if uploaded != nil && len(update) > 0 {
+        printUpdatedGist(uploaded, verbose)
         
-        gistHistory.AddGist(gist)
+        recordGist(gistHistory, uploaded, gist.ActionUpdated)
+    } else if uploaded != nil {
+        printUploadedGist(uploaded, verbose)
+        
+        recordGist(gistHistory, uploaded, gist.ActionCreated)
+    }
+    
+    if len(deletes) > 0 {
+        status := deleteGists(ctx, backend, gistHistory, deletes, yes, 
+                              isPipe)
+        if status != exitSuccess {
+            os.Exit(status)
+        }
     }
     
+    tasks := make([]util.Task, 0, len(index) + len(gets))
+    
     for _, x := range index {
-        id := gistHistory.GetGistIdAt(x)
-        
-        gist, err = api.GetGist(id)
+        tasks = append(tasks, newIndexTask(ctx, backend, gistHistory, x, 
+                                           lineNum, showComments))
+    }
+    
+    for _, x := range gets {
+        tasks = append(tasks, newGetTask(ctx, backend, gistHistory, x, 
+                                         lineNum, showComments))
+    }
+    
+    /* Failed downloads do not stop the remaining ones */
+    status := exitSuccess
+    
+    util.RunOrdered(jobs, tasks, func(err error) {
+        util.Error(err)
+        status = exitCode(err)
+    })
+    
+    for _, x := range users {
+        err = printUsersGists(ctx, backend, x, listOpts)
         if err != nil {
             util.Error(err)
-            os.Exit(1)
+            status = exitCode(err)
         }
-        
-        gistHistory.AddGist(gist)
-        
-        printReceivedGist(gist, lineNum)
     }
     
-    for _, x := range gets {
-        gist, err = api.GetGist(x)
+    if len(comment) > 0 {
+        err = postComment(ctx, api, gistHistory, comment, message, isPipe)
+        if err != nil {
+            util.Error(err)
+            os.Exit(exitCode(err))
+        }
+    }
+    
+    if len(editComment) > 0 {
+        err = editGistComment(ctx, api, gistHistory, editComment, message,
+                              isPipe)
+        if err != nil {
+            util.Error(err)
+            os.Exit(exitCode(err))
+        }
+    }
+    
+    if len(deleteComment) > 0 {
+        err = deleteGistComments(ctx, api, gistHistory, deleteComment)
+        if err != nil {
+            util.Error(err)
+            os.Exit(exitCode(err))
+        }
+    }
+    
+    for _, x := range revisions {
+        err = printRevisions(ctx, api, gistHistory, x, listOpts)
+        if err != nil {
+            util.Error(err)
+            os.Exit(exitCode(err))
+        }
+    }
+    
+    if len(diff) > 0 {
+        err = printRevisionDiff(ctx, api, gistHistory, diff)
+        if err != nil {
+            util.Error(err)
+            os.Exit(exitCode(err))
+        }
+    }
+    
+    for _, x := range forks {
+        err = forkGist(ctx, api, gistHistory, x, verbose)
+        if err != nil {
+            util.Error(err)
+            os.Exit(exitCode(err))
+        }
+    }
+    
+    for _, x := range listForks {
+        err = printForks(ctx, api, gistHistory, x, listOpts)
+        if err != nil {
+            util.Error(err)
+            os.Exit(exitCode(err))
+        }
+    }
+    
+    for _, x := range stars {
+        err = starGist(ctx, api, gistHistory, x, true)
+        if err != nil {
+            util.Error(err)
+            os.Exit(exitCode(err))
+        }
+    }
+    
+    for _, x := range unstars {
+        err = starGist(ctx, api, gistHistory, x, false)
+        if err != nil {
+            util.Error(err)
+            os.Exit(exitCode(err))
+        }
+    }
+    
+    for _, x := range isStarred {
+        err = printIsStarred(ctx, api, gistHistory, x)
+        if err != nil {
+            util.Error(err)
+            os.Exit(exitCode(err))
+        }
+    }
+    
+    if starred {
+        err = printStarredGists(ctx, api, listOpts)
+        if err != nil {
+            util.Error(err)
+            os.Exit(exitCode(err))
+        }
+    }
+    
+    if mine {
+        err = printMyGists(ctx, backend, listOpts)
+        if err != nil {
+            util.Error(err)
+            os.Exit(exitCode(err))
+        }
+    }
+    
+    if publicFeed {
+        err = printPublicFeed(ctx, api, listOpts, language, pattern)
+        if err != nil {
+            util.Error(err)
+            os.Exit(exitCode(err))
+        }
+    }
+    
+    if history || len(historySearch) > 0 {
+        untilTime, err := parseTime(until)
+        if err != nil {
+            util.Error(err)
+            os.Exit(1)
+        }
+        
+        pattern, err := newHistoryPattern(historySearch)
         if err != nil {
             util.Error(err)
             os.Exit(1)
         }
         
-        gistHistory.AddGist(gist)
+        filter := gist.HistoryFilter{
+            Pattern     : pattern, 
+            Since       : sinceTime, 
+            Until       : untilTime, 
+            CreatedOnly : createdOnly, 
+            Limit       : limit, 
+        }
         
-        printReceivedGist(gist, lineNum)
+        printHistory(gistHistory, &filter)
     }
     
-    for _, x := range users {
-        gists, err := api.GetUsersGists(x)
+    if purgeCache && cache != nil {
+        n, err := cache.Purge()
         if err != nil {
             util.Error(err)
             os.Exit(1)
         }
         
-        for _, y := range gists {
-            fmt.Printf("Gist Id: %s\n", y.Id)
-        }
+        fmt.Printf("Removed %d cached responses from %s\n", n, cache.Dir())
     }
     
-    if history {
-        fmt.Printf("Gist History:\n%s\n", gistHistory)
+    if rateLimit {
+        err = printRateLimit(ctx, api)
+        if err != nil {
+            util.Error(err)
+            os.Exit(exitCode(err))
+        }
     }
+    
+    os.Exit(status)
 }
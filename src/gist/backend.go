@@ -0,0 +1,63 @@
+/*
+ * Copyright (C) 2014  Steffen Nüssle
+ * ggist - go gist
+ *
+ * This file is part of ggist.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package gist
+
+import (
+    "context"
+    "errors"
+)
+
+/*
+ * Backend is implemented by every service gists can be stored on. GistAPI
+ * talks to GitHub, GitLab to the snippets of a GitLab instance and
+ * LocalStore keeps gists in a local directory. Stars, forks, comments and
+ * revisions are only available through GistAPI.
+ */
+type Backend interface {
+    CreateGistContext(ctx context.Context, info *GistInfo) (*Gist, error)
+    CreateSimpleGistContext(ctx context.Context, 
+                            info *SimpleGistInfo) (*Gist, error)
+    GetGistContext(ctx context.Context, id string) (*Gist, error)
+    UpdateGistContext(ctx context.Context, 
+                      id string, 
+                      info *GistUpdateInfo) (*Gist, error)
+    DeleteGistContext(ctx context.Context, id string) error
+    ForEachUsersGistContext(ctx context.Context, 
+                            user string, 
+                            opts *ListOptions, 
+                            fn func(*Gist) error) error
+    ForEachMyGistContext(ctx context.Context, 
+                         opts *ListOptions, 
+                         fn func(*Gist) error) error
+    GetAuthenticatedUserContext(ctx context.Context) (*User, error)
+    ParseGistId(s string) string
+}
+
+var (
+    _ Backend = (*GistAPI)(nil)
+    _ Backend = (*GitLab)(nil)
+    _ Backend = (*LocalStore)(nil)
+)
+
+/*
+ * Returned by backends for operations the service does not offer.
+ */
+var ErrNotSupported = errors.New("Operation not supported by this backend")
@@ -0,0 +1,219 @@
+/*
+ * Copyright (C) 2014  Steffen Nüssle
+ * ggist - go gist
+ *
+ * This file is part of ggist.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+
+package gist
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "io/ioutil"
+    "net/http"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+/*
+ * Cache stores the responses of GET requests on disk together with their
+ * ETag and Last-Modified headers. Later requests for the same url are
+ * sent as conditional requests and a "304 Not Modified" answer is served
+ * from the cache. GitHub does not count such answers against the rate
+ * limit.
+ */
+type Cache struct {
+    dir string
+}
+
+type cacheEntry struct {
+    Url string                      `json:"url"`
+    ETag string                     `json:"etag"`
+    LastModified string             `json:"last_modified"`
+    Header http.Header              `json:"header"`
+    Body []byte                     `json:"body"`
+}
+
+/*
+ * Create a cache storing its entries in dir. The directory is created
+ * if necessary.
+ */
+func NewCache(dir string) (*Cache, error) {
+    err := os.MkdirAll(dir, 0700)
+    if err != nil {
+        return nil, errors.New("os.MkdirAll() failed with: " + err.Error())
+    }
+    
+    return &Cache{dir}, nil
+}
+
+func (this *Cache) Dir() string {
+    return this.dir
+}
+
+/*
+ * Remove all entries from the cache along with temporary files left
+ * behind by interrupted writes. Returns the number of removed entries.
+ */
+func (this *Cache) Purge() (int, error) {
+    names, err := filepath.Glob(filepath.Join(this.dir, "*.json"))
+    if err != nil {
+        return 0, errors.New("filepath.Glob(): " + err.Error())
+    }
+    
+    temps, err := filepath.Glob(filepath.Join(this.dir, ".tmp-*"))
+    if err != nil {
+        return 0, errors.New("filepath.Glob(): " + err.Error())
+    }
+    
+    for _, x := range temps {
+        err = os.Remove(x)
+        if err != nil && !os.IsNotExist(err) {
+            return 0, errors.New("os.Remove() failed with: " + err.Error())
+        }
+    }
+    
+    for i, x := range names {
+        err = os.Remove(x)
+        if err != nil && !os.IsNotExist(err) {
+            return i, errors.New("os.Remove() failed with: " + err.Error())
+        }
+    }
+    
+    return len(names), nil
+}
+
+/*
+ * Responses depend on the credentials which were used to request them,
+ * so the token is part of the key. It is hashed along with the url and
+ * never written to disk.
+ */
+func (this *Cache) path(url string, token string) string {
+    sum := sha256.Sum256([]byte(token + "\n" + url))
+    
+    return filepath.Join(this.dir, hex.EncodeToString(sum[:]) + ".json")
+}
+
+/*
+ * A missing or damaged entry is not an error, the request is simply
+ * sent unconditionally.
+ */
+func (this *Cache) load(url string, token string) *cacheEntry {
+    data, err := ioutil.ReadFile(this.path(url, token))
+    if err != nil {
+        return nil
+    }
+    
+    entry := cacheEntry{}
+    
+    err = json.Unmarshal(data, &entry)
+    if err != nil || entry.Url != url {
+        return nil
+    }
+    
+    return &entry
+}
+
+/*
+ * The entry is written to a temporary file first so concurrent requests
+ * never see a partially written entry.
+ */
+func (this *Cache) store(token string, entry *cacheEntry) error {
+    data, err := json.Marshal(entry)
+    if err != nil {
+        return errors.New("json.Marshal(): " + err.Error())
+    }
+    
+    return writeFileAtomic(this.path(entry.Url, token), data)
+}
+
+/*
+ * Add the validators of a cached response to a request.
+ */
+func (this *cacheEntry) prepare(req *http.Request) {
+    if len(this.ETag) > 0 {
+        req.Header.Set("If-None-Match", this.ETag)
+    }
+    
+    if len(this.LastModified) > 0 {
+        req.Header.Set("If-Modified-Since", this.LastModified)
+    }
+}
+
+/*
+ * Turn a "304 Not Modified" answer into the cached "200 OK" response.
+ * The rate limit headers of the fresh answer are kept.
+ */
+func (this *cacheEntry) response(resp *http.Response) *http.Response {
+    resp.Body.Close()
+    
+    header := this.Header.Clone()
+    if header == nil {
+        header = http.Header{}
+    }
+    
+    for key, values := range resp.Header {
+        if strings.HasPrefix(key, "X-Ratelimit-") {
+            header[key] = values
+        }
+    }
+    
+    ret := *resp
+    ret.Status        = "200 OK"
+    ret.StatusCode    = 200
+    ret.Header        = header
+    ret.Body          = ioutil.NopCloser(bytes.NewReader(this.Body))
+    ret.ContentLength = int64(len(this.Body))
+    
+    return &ret
+}
+
+/*
+ * Store a "200 OK" response if the server sent a validator. The body
+ * is read completely and replaced with an in-memory copy.
+ */
+func (this *Cache) update(resp *http.Response, 
+                          url string, 
+                          token string) error {
+    etag := resp.Header.Get("ETag")
+    lastModified := resp.Header.Get("Last-Modified")
+    
+    if resp.StatusCode != 200 || len(etag) + len(lastModified) == 0 {
+        return nil
+    }
+    
+    body, err := ioutil.ReadAll(resp.Body)
+    resp.Body.Close()
+    
+    if err != nil {
+        return &NetworkError{"GET", url, err}
+    }
+    
+    resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+    
+    entry := cacheEntry{url, etag, lastModified, resp.Header, body}
+    
+    /* A cache which cannot be written must not fail the request */
+    this.store(token, &entry)
+    
+    return nil
+}
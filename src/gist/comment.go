@@ -0,0 +1,178 @@
+/*
+ * Copyright (C) 2014  Steffen Nüssle
+ * ggist - go gist
+ *
+ * This file is part of ggist.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package gist
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "time"
+)
+
+type Comment struct {
+    Id int64                        `json:"id"`
+    ApiUrl string                   `json:"url"`
+    Body string                     `json:"body"`
+    User *User                      `json:"user"`
+    CreatedAt time.Time             `json:"created_at"`
+    UpdatedAt time.Time             `json:"updated_at"`
+}
+
+type localComment struct {
+    Body string                     `json:"body"`
+}
+
+func (this *GistAPI) ListComments(id string, 
+                                  opts *ListOptions) ([]Comment, error) {
+    return this.ListCommentsContext(context.Background(), id, opts)
+}
+
+func (this *GistAPI) ListCommentsContext(ctx context.Context, 
+                                         id string, 
+                                         opts *ListOptions) ([]Comment, error) {
+    comments := make([]Comment, 0, 32)
+    
+    err := this.ForEachCommentContext(ctx, id, opts, func(x *Comment) error {
+        comments = append(comments, *x)
+        
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    
+    return comments, nil
+}
+
+func (this *GistAPI) ForEachComment(id string, 
+                                    opts *ListOptions, 
+                                    fn func(*Comment) error) error {
+    return this.ForEachCommentContext(context.Background(), id, opts, fn)
+}
+
+func (this *GistAPI) ForEachCommentContext(ctx context.Context, 
+                                           id string, 
+                                           opts *ListOptions, 
+                                           fn func(*Comment) error) error {
+    url := this.commentsUrl(id)
+    
+    return this.forEachItem(ctx, url, opts, func(data json.RawMessage) error {
+        comment := Comment{}
+        
+        err := json.Unmarshal(data, &comment)
+        if err != nil {
+            return errors.New("json.Unmarshal(): " + err.Error())
+        }
+        
+        return fn(&comment)
+    })
+}
+
+func (this *GistAPI) CreateComment(id string, body string) (*Comment, error) {
+    return this.CreateCommentContext(context.Background(), id, body)
+}
+
+func (this *GistAPI) CreateCommentContext(ctx context.Context, 
+                                          id string, 
+                                          body string) (*Comment, error) {
+    return this.sendComment(ctx, "POST", this.commentsUrl(id), body, 201)
+}
+
+func (this *GistAPI) UpdateComment(id string, 
+                                   commentId int64, 
+                                   body string) (*Comment, error) {
+    return this.UpdateCommentContext(context.Background(), id, commentId, body)
+}
+
+func (this *GistAPI) UpdateCommentContext(ctx context.Context, 
+                                          id string, 
+                                          commentId int64, 
+                                          body string) (*Comment, error) {
+    url := fmt.Sprintf("%s/%d", this.commentsUrl(id), commentId)
+    
+    return this.sendComment(ctx, "PATCH", url, body, 200)
+}
+
+func (this *GistAPI) DeleteComment(id string, commentId int64) error {
+    return this.DeleteCommentContext(context.Background(), id, commentId)
+}
+
+func (this *GistAPI) DeleteCommentContext(ctx context.Context, 
+                                          id string, 
+                                          commentId int64) error {
+    url := fmt.Sprintf("%s/%d", this.commentsUrl(id), commentId)
+    
+    resp, err := this.getResponse(ctx, "DELETE", url, nil)
+    if err != nil {
+        return err
+    }
+    
+    defer resp.Body.Close()
+    
+    /* 204 - No Content */
+    if resp.StatusCode != 204 {
+        return newApiError(resp)
+    }
+    
+    return nil
+}
+
+func (this *GistAPI) sendComment(ctx context.Context, 
+                                 what string, 
+                                 url string, 
+                                 body string, 
+                                 status int) (*Comment, error) {
+    if len(body) == 0 {
+        return nil, errors.New("Failed to send comment: empty body")
+    }
+    
+    msg_data, err := json.Marshal(localComment{body})
+    if err != nil {
+        return nil, errors.New("json.Marshal(): " + err.Error())
+    }
+    
+    resp, err := this.getResponse(ctx, what, url, msg_data)
+    if err != nil {
+        return nil, err
+    }
+    
+    defer resp.Body.Close()
+    
+    if resp.StatusCode != status {
+        return nil, newApiError(resp)
+    }
+    
+    comment := Comment{}
+    
+    err = json.NewDecoder(resp.Body).Decode(&comment)
+    if err != nil {
+        return nil, errors.New("json.NewDecoder.Decode(): " + err.Error())
+    }
+    
+    return &comment, nil
+}
+
+func (this *GistAPI) commentsUrl(id string) string {
+    id = this.ensureIsGistId(id)
+    
+    return fmt.Sprintf("%s/gists/%s/comments", this.apiUrl, id)
+}
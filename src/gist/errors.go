@@ -0,0 +1,216 @@
+/*
+ * Copyright (C) 2014  Steffen Nüssle
+ * ggist - go gist
+ *
+ * This file is part of ggist.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package gist
+
+import (
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "net/http"
+    "strconv"
+    "time"
+)
+
+/*
+ * A single entry of the "errors" list the server sends along with
+ * validation failures.
+ */
+type FieldError struct {
+    Resource string                 `json:"resource"`
+    Field string                    `json:"field"`
+    Code string                     `json:"code"`
+    Message string                  `json:"message"`
+}
+
+/*
+ * An ApiError is returned whenever the server answers a request with an
+ * unexpected status code. The more specific error types below all embed
+ * an ApiError and unwrap to it, so callers can use errors.As with either
+ * the specific type or *ApiError.
+ */
+type ApiError struct {
+    StatusCode int
+    Status string
+    Message string                  `json:"message"`
+    DocumentationUrl string         `json:"documentation_url"`
+    Errors []FieldError             `json:"errors"`
+}
+
+func (this *ApiError) Error() string {
+    msg := "Server returned: " + this.Status
+    
+    if len(this.Message) > 0 {
+        msg += " - " + this.Message
+    }
+    
+    length := len(this.Errors)
+    
+    for i, x := range this.Errors {
+        msg += fmt.Sprintf("\nError (%d / %d):\n", i + 1, length)
+        msg += fmt.Sprintf("  Resource: %s\n  Field: %s\n  Code: %s",
+                           x.Resource, x.Field, x.Code)
+        
+        if len(x.Message) > 0 {
+            msg += "\n  Message: " + x.Message
+        }
+    }
+    
+    return msg
+}
+
+/* 401 - Unauthorized */
+type UnauthorizedError struct {
+    ApiError
+}
+
+func (this *UnauthorizedError) Unwrap() error {
+    return &this.ApiError
+}
+
+/* 403 - Forbidden, unless caused by an exceeded rate limit */
+type ForbiddenError struct {
+    ApiError
+}
+
+func (this *ForbiddenError) Unwrap() error {
+    return &this.ApiError
+}
+
+/* 404 - Not Found */
+type NotFoundError struct {
+    ApiError
+}
+
+func (this *NotFoundError) Unwrap() error {
+    return &this.ApiError
+}
+
+/* 422 - Unprocessable Entity */
+type ValidationError struct {
+    ApiError
+}
+
+func (this *ValidationError) Unwrap() error {
+    return &this.ApiError
+}
+
+/*
+ * 403 or 429 with an exhausted quota. Reset holds the time at which the
+ * quota is refilled, RetryAfter the delay requested by the server. Both
+ * are zero if the server did not provide them.
+ */
+type RateLimitError struct {
+    ApiError
+    Reset time.Time
+    RetryAfter time.Duration
+}
+
+func (this *RateLimitError) Error() string {
+    msg := this.ApiError.Error()
+    
+    if !this.Reset.IsZero() {
+        msg += "\nRate limit resets at " + this.Reset.Format(time.RFC1123)
+    }
+    
+    return msg
+}
+
+func (this *RateLimitError) Unwrap() error {
+    return &this.ApiError
+}
+
+/*
+ * A NetworkError is returned if no response was received at all.
+ */
+type NetworkError struct {
+    Method string
+    Url string
+    Err error
+}
+
+func (this *NetworkError) Error() string {
+    return "Network error: " + this.Err.Error()
+}
+
+func (this *NetworkError) Unwrap() error {
+    return this.Err
+}
+
+/*
+ * Build the error matching the status code of the response. The body of
+ * the response is consumed but not closed.
+ */
+func newApiError(resp *http.Response) error {
+    apiError := ApiError{}
+    
+    data, err := ioutil.ReadAll(resp.Body)
+    if err == nil && len(data) > 0 {
+        /* Not every error response carries a JSON body */
+        json.Unmarshal(data, &apiError)
+    }
+    
+    apiError.StatusCode = resp.StatusCode
+    apiError.Status     = resp.Status
+    
+    switch resp.StatusCode {
+    case 401:
+        return &UnauthorizedError{apiError}
+    case 403, 429:
+        if isRateLimited(resp) {
+            reset, retryAfter := rateLimitDelays(resp.Header)
+            
+            return &RateLimitError{apiError, reset, retryAfter}
+        }
+        
+        if resp.StatusCode == 403 {
+            return &ForbiddenError{apiError}
+        }
+    case 404:
+        return &NotFoundError{apiError}
+    case 422:
+        return &ValidationError{apiError}
+    }
+    
+    return &apiError
+}
+
+func isRateLimited(resp *http.Response) bool {
+    return resp.StatusCode == 429 ||
+           resp.Header.Get("X-RateLimit-Remaining") == "0" ||
+           len(resp.Header.Get("Retry-After")) > 0
+}
+
+func rateLimitDelays(header http.Header) (time.Time, time.Duration) {
+    reset := time.Time{}
+    retryAfter := time.Duration(0)
+    
+    sec, err := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64)
+    if err == nil {
+        reset = time.Unix(sec, 0)
+    }
+    
+    n, err := strconv.Atoi(header.Get("Retry-After"))
+    if err == nil {
+        retryAfter = time.Duration(n) * time.Second
+    }
+    
+    return reset, retryAfter
+}
@@ -0,0 +1,90 @@
+/*
+ * Copyright (C) 2014  Steffen Nüssle
+ * ggist - go gist
+ *
+ * This file is part of ggist.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package gist
+
+import (
+    "context"
+    "fmt"
+)
+
+/*
+ * Fork the gist with the given id and return the newly created gist.
+ */
+func (this *GistAPI) Fork(id string) (*Gist, error) {
+    return this.ForkContext(context.Background(), id)
+}
+
+func (this *GistAPI) ForkContext(ctx context.Context, 
+                                 id string) (*Gist, error) {
+    resp, err := this.getResponse(ctx, "POST", this.forksUrl(id), nil)
+    if err != nil {
+        return nil, err
+    }
+    
+    defer resp.Body.Close()
+    
+    /* 201 - Created */
+    if resp.StatusCode != 201 {
+        return nil, newApiError(resp)
+    }
+    
+    return decodeGist(resp.Body)
+}
+
+func (this *GistAPI) ListForks(id string, opts *ListOptions) ([]Gist, error) {
+    return this.ListForksContext(context.Background(), id, opts)
+}
+
+func (this *GistAPI) ListForksContext(ctx context.Context, 
+                                      id string, 
+                                      opts *ListOptions) ([]Gist, error) {
+    gists := make([]Gist, 0, 32)
+    
+    err := this.ForEachForkContext(ctx, id, opts, func(gist *Gist) error {
+        gists = append(gists, *gist)
+        
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    
+    return gists, nil
+}
+
+func (this *GistAPI) ForEachFork(id string, 
+                                 opts *ListOptions, 
+                                 fn func(*Gist) error) error {
+    return this.ForEachForkContext(context.Background(), id, opts, fn)
+}
+
+func (this *GistAPI) ForEachForkContext(ctx context.Context, 
+                                        id string, 
+                                        opts *ListOptions, 
+                                        fn func(*Gist) error) error {
+    return this.forEachGist(ctx, this.forksUrl(id), opts, fn)
+}
+
+func (this *GistAPI) forksUrl(id string) string {
+    id = this.ensureIsGistId(id)
+    
+    return fmt.Sprintf("%s/gists/%s/forks", this.apiUrl, id)
+}
@@ -22,18 +22,42 @@ package gist
 
 import (
     "bytes"
+    "context"
     "errors"
     "encoding/json"
     "fmt"
     "io"
     "io/ioutil"
     "net/http"
+    "net/url"
     "path"
     "strings"
+    "sync"
+    "time"
 )
 
+const (
+    DefaultApiUrl  = "https://api.github.com"
+    DefaultHtmlUrl = "https://gist.github.com"
+)
+
+/*
+ * All methods talking to the server come in two flavors: Xxx and
+ * XxxContext. The latter stops waiting for the server, for rate limits
+ * and for retries as soon as the passed context is done.
+ */
 type GistAPI struct {
     client http.Client
+    token string
+    userAgent string
+    apiUrl string
+    htmlUrl string
+    maxFileSize int64
+    rateLimit *RateLimit
+    rateLimitMutex sync.Mutex
+    rateLimitPolicy RateLimitPolicy
+    maxRetries int
+    cache *Cache
 }
 
 type GistInfo struct {
@@ -49,124 +73,561 @@ type SimpleGistInfo struct {
     Data []byte
 }
 
+/*
+ * Changes which are applied to an existing gist by UpdateGist. Files are
+ * read from disk and added to the gist or replace the file with the same
+ * name. Renames maps old file names to new ones and Deletes lists the
+ * names of files which are removed from the gist. An empty description
+ * leaves the current one untouched.
+ */
+type GistUpdateInfo struct {
+    Description string
+    Files []string
+    Renames map[string]string
+    Deletes []string
+}
+
+type User struct {
+    Login string                    `json:"login"`
+    Id int64                        `json:"id"`
+    Url string                      `json:"html_url"`
+    AvatarUrl string                `json:"avatar_url"`
+    Type string                     `json:"type"`
+}
+
+type GistFile struct {
+    FileName string                 `json:"filename"`
+    Type     string                 `json:"type"`
+    Size     int64                  `json:"size"`
+    Language string                 `json:"language"`
+    Content  string                 `json:"content"`
+    RawUrl   string                 `json:"raw_url"`
+    Truncated bool                  `json:"truncated"`
+}
+
+type GistFork struct {
+    Id string                       `json:"id"`
+    ApiUrl string                   `json:"url"`
+    User *User                      `json:"user"`
+    CreatedAt time.Time             `json:"created_at"`
+    UpdatedAt time.Time             `json:"updated_at"`
+}
+
+type ChangeStatus struct {
+    Total int                       `json:"total"`
+    Additions int                   `json:"additions"`
+    Deletions int                   `json:"deletions"`
+}
+
+type GistRevision struct {
+    Version string                  `json:"version"`
+    ApiUrl string                   `json:"url"`
+    User *User                      `json:"user"`
+    CommittedAt time.Time           `json:"committed_at"`
+    ChangeStatus ChangeStatus       `json:"change_status"`
+}
+
 type Gist struct {
     Url string                  `json:"html_url"`
     Id  string                  `json:"id"`
     Description string          `json:"description"`
-    Files       map[string]struct {
-        Size     int64                  `json:"size"`
-        Language string                 `json:"language"`
-        Content  string                 `json:"content"`
-    }                           `json:"files"`
+    Files       map[string]*GistFile `json:"files"`
     Public bool                 `json:"public"`
+    ApiUrl string               `json:"url"`
+    Owner *User                 `json:"owner"`
+    CreatedAt time.Time         `json:"created_at"`
+    UpdatedAt time.Time         `json:"updated_at"`
+    Comments int                `json:"comments"`
+    CommentsUrl string          `json:"comments_url"`
+    GitPullUrl string           `json:"git_pull_url"`
+    GitPushUrl string           `json:"git_push_url"`
+    Truncated bool              `json:"truncated"`
+    Forks []GistFork            `json:"forks"`
+    History []GistRevision      `json:"history"`
 }
 
 type file struct {
     Content string              `json:"content"`
 }
 
+type fileUpdate struct {
+    Content *string             `json:"content,omitempty"`
+    FileName *string            `json:"filename,omitempty"`
+}
+
+type localGistUpdate struct {
+    Description string                  `json:"description,omitempty"`
+    Files map[string]*fileUpdate        `json:"files,omitempty"`
+}
+
 type localGist struct {
     Description string              `json:"description"`
     Public bool                     `json:"public"`
     Files map[string]file           `json:"files"`
 }
 
-func NewGistAPI() *GistAPI {
-    return &GistAPI{http.Client{}}
+/*
+ * Create a new GistAPI talking to GitHub anonymously. Pass options to
+ * change the defaults, e.g. NewGistAPI(WithToken(token)).
+ */
+func NewGistAPI(options ...Option) *GistAPI {
+    api := GistAPI{}
+    api.userAgent  = DefaultUserAgent
+    api.apiUrl     = DefaultApiUrl
+    api.htmlUrl    = DefaultHtmlUrl
+    api.maxRetries = DefaultMaxRetries
+    
+    for _, option := range options {
+        option(&api)
+    }
+    
+    return &api
+}
+
+/*
+ * Set the personal access token which is used to authenticate all
+ * subsequent requests. An empty token results in anonymous requests.
+ */
+func (this *GistAPI) SetToken(token string) {
+    this.token = strings.TrimSpace(token)
+}
+
+func (this *GistAPI) IsAuthenticated() bool {
+    return len(this.token) > 0
+}
+
+/*
+ * Set the base url of the REST API, e.g. "https://github.example.com/api/v3"
+ * for a GitHub Enterprise instance. An empty url restores the default.
+ */
+func (this *GistAPI) SetApiUrl(url string) {
+    this.apiUrl = normalizeBaseUrl(url, DefaultApiUrl)
+}
+
+func (this *GistAPI) ApiUrl() string {
+    return this.apiUrl
+}
+
+/*
+ * Set the base url under which gists are shown in a browser, e.g.
+ * "https://github.example.com/gist". It is used to extract gist ids
+ * from urls. An empty url restores the default.
+ */
+func (this *GistAPI) SetHtmlUrl(url string) {
+    this.htmlUrl = normalizeBaseUrl(url, DefaultHtmlUrl)
+}
+
+func (this *GistAPI) HtmlUrl() string {
+    return this.htmlUrl
+}
+
+/*
+ * Extract the id of a gist from its url. Anything which does not look
+ * like a gist url is returned unchanged.
+ */
+func (this *GistAPI) ParseGistId(s string) string {
+    return this.ensureIsGistId(s)
+}
+
+/*
+ * The server truncates the content of large files which GetGist then
+ * downloads separately. Files larger than size bytes are left truncated.
+ * A size of zero removes the limit.
+ */
+func (this *GistAPI) SetMaxFileSize(size int64) {
+    this.maxFileSize = size
+}
+
+/*
+ * Send GET requests as conditional requests and serve unchanged responses
+ * from cache. A nil cache disables caching.
+ */
+func (this *GistAPI) SetCache(cache *Cache) {
+    this.cache = cache
+}
+
+/*
+ * Limit the time a single request may take including reading the
+ * response body. Zero means no limit.
+ */
+func (this *GistAPI) SetTimeout(timeout time.Duration) {
+    this.client.Timeout = timeout
 }
 
 func (this *GistAPI) CreateGist(info *GistInfo) (*Gist, error) {
+    return this.CreateGistContext(context.Background(), info)
+}
+
+func (this *GistAPI) CreateGistContext(ctx context.Context, 
+                                       info *GistInfo) (*Gist, error) {
      gist, err := newLocalGist(info.Description, info.Public, &info.Files)
      if err != nil {
          return nil, err
      }
      
-     return this.uploadLocalGist(gist)
+     return this.uploadLocalGist(ctx, gist)
 }
 
 func (this *GistAPI) CreateSimpleGist(info *SimpleGistInfo) (*Gist, error) {
+    return this.CreateSimpleGistContext(context.Background(), info)
+}
+
+func (this *GistAPI) CreateSimpleGistContext(
+                                    ctx context.Context, 
+                                    info *SimpleGistInfo) (*Gist, error) {
     
     gist := &localGist{info.Description, info.Public, make(map[string]file)}
 
     gist.Files[info.FileName] = file{string(info.Data)}
     
-    return this.uploadLocalGist(gist)
+    return this.uploadLocalGist(ctx, gist)
 }
 
 func (this *GistAPI) DeleteGist(id string) error {
-    id = ensureIsGistId(id)
+    return this.DeleteGistContext(context.Background(), id)
+}
+
+func (this *GistAPI) DeleteGistContext(ctx context.Context, id string) error {
+    id = this.ensureIsGistId(id)
     
-    url := fmt.Sprintf("https://api.github.com/gists/%s", id)
+    url := fmt.Sprintf("%s/gists/%s", this.apiUrl, id)
     
-    resp, err := this.getResponse("DELETE", url, nil)
+    resp, err := this.getResponse(ctx, "DELETE", url, nil)
     if err != nil {
         return err
     }
     
+    defer resp.Body.Close()
+    
     /* 204: No Content */
     if resp.StatusCode != 204 {
-        return errors.New(resp.Status)
+        return newApiError(resp)
     }
     
     return nil
 }
 
 func (this *GistAPI) GetGist(id string) (*Gist, error) {
-    id = ensureIsGistId(id)
+    return this.GetGistContext(context.Background(), id)
+}
+
+func (this *GistAPI) GetGistContext(ctx context.Context, 
+                                    id string) (*Gist, error) {
+    id = this.ensureIsGistId(id)
     
-    url := fmt.Sprintf("https://api.github.com/gists/%s", id)
+    url := fmt.Sprintf("%s/gists/%s", this.apiUrl, id)
     
-    resp, err := this.getResponse("GET", url, nil)
+    return this.getGist(ctx, url)
+}
+
+/*
+ * Return the user the token belongs to.
+ */
+func (this *GistAPI) GetAuthenticatedUser() (*User, error) {
+    return this.GetAuthenticatedUserContext(context.Background())
+}
+
+func (this *GistAPI) GetAuthenticatedUserContext(
+                                    ctx context.Context) (*User, error) {
+    resp, err := this.getResponse(ctx, "GET", this.apiUrl + "/user", nil)
     if err != nil {
         return nil, err
     }
     
     defer resp.Body.Close()
     
-    return decodeGist(resp.Body)
+    /* 200 - OK */
+    if resp.StatusCode != 200 {
+        return nil, newApiError(resp)
+    }
+    
+    user := User{}
+    
+    err = json.NewDecoder(resp.Body).Decode(&user)
+    if err != nil {
+        return nil, errors.New("json.NewDecoder.Decode(): " + err.Error())
+    }
+    
+    return &user, nil
 }
 
+func (this *GistAPI) getGist(ctx context.Context, url string) (*Gist, error) {
+    resp, err := this.getResponse(ctx, "GET", url, nil)
+    if err != nil {
+        return nil, err
+    }
+    
+    defer resp.Body.Close()
+    
+    /* 200 - OK */
+    if resp.StatusCode != 200 {
+        return nil, newApiError(resp)
+    }
+    
+    gist, err := decodeGist(resp.Body)
+    if err != nil {
+        return nil, err
+    }
+    
+    err = this.completeTruncatedFiles(ctx, gist)
+    if err != nil {
+        return nil, err
+    }
+    
+    return gist, nil
+}
+
+/*
+ * Return all public gists of the user. Use ListUsersGists() to limit the
+ * number of gists or to request them in larger pages.
+ */
 func (this *GistAPI) GetUsersGists(user string) ([]Gist, error) {
-    url := fmt.Sprintf("https://api.github.com/users/%s/gists", user)
+    return this.ListUsersGistsContext(context.Background(), user, nil)
+}
+
+func (this *GistAPI) GetUsersGistsContext(ctx context.Context, 
+                                          user string) ([]Gist, error) {
+    return this.ListUsersGistsContext(ctx, user, nil)
+}
+
+func (this *GistAPI) ListUsersGists(user string, 
+                                    opts *ListOptions) ([]Gist, error) {
+    return this.ListUsersGistsContext(context.Background(), user, opts)
+}
 
-    resp, err := this.getResponse("GET", url, nil)
+func (this *GistAPI) ListUsersGistsContext(ctx context.Context, 
+                                           user string, 
+                                           opts *ListOptions) ([]Gist, error) {
+    gists := make([]Gist, 0, 32)
+    
+    fn := func(gist *Gist) error {
+        gists = append(gists, *gist)
+        
+        return nil
+    }
+    
+    err := this.ForEachUsersGistContext(ctx, user, opts, fn)
     if err != nil {
         return nil, err
     }
     
-    defer resp.Body.Close()
+    return gists, nil
+}
+
+/*
+ * Call fn for each gist of the user as soon as the page containing it was
+ * received. Returning ErrStop from fn ends the listing without an error.
+ */
+func (this *GistAPI) ForEachUsersGist(user string, 
+                                      opts *ListOptions, 
+                                      fn func(*Gist) error) error {
+    return this.ForEachUsersGistContext(context.Background(), user, opts, fn)
+}
+
+func (this *GistAPI) ForEachUsersGistContext(ctx context.Context, 
+                                             user string, 
+                                             opts *ListOptions, 
+                                             fn func(*Gist) error) error {
+    url := fmt.Sprintf("%s/users/%s/gists", this.apiUrl, user)
     
-    if (resp.StatusCode != 200) {
-        return nil, errors.New(resp.Status)
+    return this.forEachGist(ctx, url, opts, fn)
+}
+
+/*
+ * Return the gists of the authenticated user including secret ones.
+ */
+func (this *GistAPI) ListMyGists(opts *ListOptions) ([]Gist, error) {
+    return this.ListMyGistsContext(context.Background(), opts)
+}
+
+func (this *GistAPI) ListMyGistsContext(ctx context.Context, 
+                                        opts *ListOptions) ([]Gist, error) {
+    gists := make([]Gist, 0, 32)
+    
+    err := this.ForEachMyGistContext(ctx, opts, func(gist *Gist) error {
+        gists = append(gists, *gist)
+        
+        return nil
+    })
+    if err != nil {
+        return nil, err
     }
     
-    gists := make([]Gist, 10)
+    return gists, nil
+}
+
+func (this *GistAPI) ForEachMyGist(opts *ListOptions, 
+                                   fn func(*Gist) error) error {
+    return this.ForEachMyGistContext(context.Background(), opts, fn)
+}
+
+func (this *GistAPI) ForEachMyGistContext(ctx context.Context, 
+                                          opts *ListOptions, 
+                                          fn func(*Gist) error) error {
+    /* Without a token the server would answer with public gists */
+    if !this.IsAuthenticated() {
+        return errors.New("Listing your own gists requires a token")
+    }
     
-    err = json.NewDecoder(resp.Body).Decode(&gists)
+    return this.forEachGist(ctx, this.apiUrl + "/gists", opts, fn)
+}
+
+/*
+ * Return all public gists, the most recently created first. The server
+ * limits this listing to the latest 3000 gists.
+ */
+func (this *GistAPI) ListPublic(opts *ListOptions) ([]Gist, error) {
+    return this.ListPublicContext(context.Background(), opts)
+}
+
+func (this *GistAPI) ListPublicContext(ctx context.Context, 
+                                       opts *ListOptions) ([]Gist, error) {
+    gists := make([]Gist, 0, 32)
+    
+    err := this.ForEachPublicContext(ctx, opts, func(gist *Gist) error {
+        gists = append(gists, *gist)
+        
+        return nil
+    })
     if err != nil {
-        return nil, errors.New("json.NewDecoder.Decode(): " + err.Error())
+        return nil, err
     }
     
     return gists, nil
 }
 
-func (this *GistAPI) UpdateGist(id string, desc string, files []string) error {
-    return nil
+func (this *GistAPI) ForEachPublic(opts *ListOptions, 
+                                   fn func(*Gist) error) error {
+    return this.ForEachPublicContext(context.Background(), opts, fn)
+}
+
+func (this *GistAPI) ForEachPublicContext(ctx context.Context, 
+                                          opts *ListOptions, 
+                                          fn func(*Gist) error) error {
+    return this.forEachGist(ctx, this.apiUrl + "/gists/public", opts, fn)
+}
+
+func (this *GistAPI) UpdateGist(id string, 
+                                info *GistUpdateInfo) (*Gist, error) {
+    return this.UpdateGistContext(context.Background(), id, info)
 }
 
-func (this *GistAPI) getResponse(what string, 
+func (this *GistAPI) UpdateGistContext(ctx context.Context, 
+                                       id string, 
+                                       info *GistUpdateInfo) (*Gist, error) {
+    update, err := newLocalGistUpdate(info)
+    if err != nil {
+        return nil, err
+    }
+    
+    msg_data, err := json.Marshal(update)
+    if err != nil {
+        return nil, errors.New("json.Marshal(): " + err.Error())
+    }
+    
+    id = this.ensureIsGistId(id)
+    
+    url := fmt.Sprintf("%s/gists/%s", this.apiUrl, id)
+    
+    resp, err := this.getResponse(ctx, "PATCH", url, msg_data)
+    if err != nil {
+        return nil, err
+    }
+    
+    defer resp.Body.Close()
+    
+    /* 200 - OK */
+    if resp.StatusCode != 200 {
+        return nil, newApiError(resp)
+    }
+    
+    return decodeGist(resp.Body)
+}
+
+/*
+ * Send a request while respecting the rate limit. Requests failing due to
+ * server errors or secondary rate limits are retried.
+ */
+func (this *GistAPI) getResponse(ctx context.Context, 
+                                 what string, 
                                  url string, 
                                  data []byte) (*http.Response, error) {
-    msg, err := newRequest(what, url, data)
+    for attempt := 0; ; attempt++ {
+        err := this.awaitRateLimit(ctx)
+        if err != nil {
+            return nil, err
+        }
+        
+        resp, err := this.doRequest(ctx, what, url, data)
+        if err != nil {
+            return nil, err
+        }
+        
+        delay, retry := this.retryDelay(resp, attempt)
+        if !retry {
+            return resp, nil
+        }
+        
+        resp.Body.Close()
+        
+        err = sleep(ctx, delay)
+        if err != nil {
+            return nil, err
+        }
+    }
+}
+
+func (this *GistAPI) doRequest(ctx context.Context, 
+                               what string, 
+                               url string, 
+                               data []byte) (*http.Response, error) {
+    msg, err := newRequest(ctx, what, url, data)
     if err != nil {
         return nil, err
     }
     
-    return this.client.Do(msg)
+    msg.Header.Set("User-Agent", this.userAgent)
+    
+    if len(this.token) > 0 {
+        msg.Header.Add("Authorization", "token " + this.token)
+    }
+    
+    var entry *cacheEntry
+    
+    if this.cache != nil && what == "GET" {
+        entry = this.cache.load(url, this.token)
+        if entry != nil {
+            entry.prepare(msg)
+        }
+    }
+    
+    resp, err := this.client.Do(msg)
+    if err != nil {
+        return nil, &NetworkError{what, url, err}
+    }
+    
+    this.updateRateLimit(resp.Header)
+    
+    switch {
+    case entry != nil && resp.StatusCode == http.StatusNotModified:
+        resp = entry.response(resp)
+    case this.cache != nil && what == "GET":
+        err = this.cache.update(resp, url, this.token)
+        if err != nil {
+            return nil, err
+        }
+    }
+    
+    return resp, nil
 }
 
-func newRequest(what string, url string, data []byte) (*http.Request, error) {
-    msg, err := http.NewRequest(what, url, bytes.NewReader(data))
+func newRequest(ctx context.Context, 
+                what string, 
+                url string, 
+                data []byte) (*http.Request, error) {
+    body := bytes.NewReader(data)
+    
+    msg, err := http.NewRequestWithContext(ctx, what, url, body)
     if err != nil {
         return nil, err
     }
@@ -201,6 +662,226 @@ func newLocalGist(desc string,
     return &gist, nil
 }
 
+func (this *GistAPI) completeTruncatedFiles(ctx context.Context, 
+                                            gist *Gist) error {
+    for key, val := range gist.Files {
+        if !val.Truncated || len(val.RawUrl) == 0 {
+            continue
+        }
+        
+        if this.maxFileSize > 0 && val.Size > this.maxFileSize {
+            continue
+        }
+        
+        content, err := this.getRawContent(ctx, val.RawUrl)
+        if err != nil {
+            return fmt.Errorf("Failed to download %s: %w", key, err)
+        }
+        
+        val.Content   = content
+        val.Truncated = false
+    }
+    
+    return nil
+}
+
+/*
+ * Raw files may be served by any host, so they are not requested like
+ * API resources. The token is only sent to the host of the API and the
+ * answers do not affect the rate limit.
+ */
+func (this *GistAPI) getRawContent(ctx context.Context, 
+                                   rawUrl string) (string, error) {
+    msg, err := http.NewRequestWithContext(ctx, "GET", rawUrl, nil)
+    if err != nil {
+        return "", err
+    }
+    
+    msg.Header.Set("User-Agent", this.userAgent)
+    
+    if len(this.token) > 0 && isSameOrigin(rawUrl, this.apiUrl) {
+        msg.Header.Add("Authorization", "token " + this.token)
+    }
+    
+    resp, err := this.client.Do(msg)
+    if err != nil {
+        return "", &NetworkError{"GET", rawUrl, err}
+    }
+    
+    defer resp.Body.Close()
+    
+    /* 200 - OK */
+    if resp.StatusCode != 200 {
+        return "", newApiError(resp)
+    }
+    
+    builder := strings.Builder{}
+    
+    _, err = io.Copy(&builder, resp.Body)
+    if err != nil {
+        return "", errors.New("io.Copy(): " + err.Error())
+    }
+    
+    return builder.String(), nil
+}
+
+func isSameOrigin(a string, b string) bool {
+    x, err := url.Parse(a)
+    if err != nil {
+        return false
+    }
+    
+    y, err := url.Parse(b)
+    if err != nil {
+        return false
+    }
+    
+    return x.Scheme == y.Scheme && strings.EqualFold(x.Host, y.Host)
+}
+
+func newLocalGistUpdate(info *GistUpdateInfo) (*localGistUpdate, error) {
+    update := localGistUpdate{info.Description, make(map[string]*fileUpdate)}
+    
+    for _, x := range info.Files {
+        data, err := ioutil.ReadFile(x)
+        if err != nil {
+            return nil, errors.New("ioutil.ReadFile(): " + err.Error())
+        }
+        
+        if len(data) == 0 {
+            return nil, errors.New("File " + x + " is empty - abort.")
+        }
+        
+        content := string(data)
+        
+        update.Files[path.Base(x)] = &fileUpdate{&content, nil}
+    }
+    
+    for key, val := range info.Renames {
+        name := val
+        
+        /* Renamed files may also get a new content at the same time */
+        if x, ok := update.Files[key]; ok {
+            x.FileName = &name
+        } else {
+            update.Files[key] = &fileUpdate{nil, &name}
+        }
+    }
+    
+    for _, x := range info.Deletes {
+        if _, ok := update.Files[x]; ok {
+            return nil, errors.New("File " + x + " is both updated and deleted")
+        }
+        
+        /* A file without any content is deleted by the server */
+        update.Files[x] = nil
+    }
+    
+    if len(update.Description) == 0 && len(update.Files) == 0 {
+        return nil, errors.New("Failed to update gist: nothing to update")
+    }
+    
+    return &update, nil
+}
+
+func (this *GistAPI) forEachGist(ctx context.Context, 
+                                 url string, 
+                                 opts *ListOptions, 
+                                 fn func(*Gist) error) error {
+    return this.forEachItem(ctx, url, opts, func(data json.RawMessage) error {
+        gist := Gist{}
+        
+        err := json.Unmarshal(data, &gist)
+        if err != nil {
+            return errors.New("json.Unmarshal(): " + err.Error())
+        }
+        
+        return fn(&gist)
+    })
+}
+
+/*
+ * Walk through all pages of a list endpoint by following the "next" links
+ * sent by the server and call fn for each item of the received pages.
+ */
+func (this *GistAPI) forEachItem(ctx context.Context, 
+                                 url string, 
+                                 opts *ListOptions, 
+                                 fn func(json.RawMessage) error) error {
+    if opts == nil {
+        opts = &ListOptions{}
+    }
+    
+    next, err := opts.apply(url)
+    if err != nil {
+        return err
+    }
+    
+    n := 0
+    
+    for len(next) > 0 {
+        resp, err := this.getResponse(ctx, "GET", next, nil)
+        if err != nil {
+            return err
+        }
+        
+        /* 200 - OK */
+        if resp.StatusCode != 200 {
+            defer resp.Body.Close()
+            return newApiError(resp)
+        }
+        
+        items := make([]json.RawMessage, 0, 32)
+        
+        err = json.NewDecoder(resp.Body).Decode(&items)
+        resp.Body.Close()
+        if err != nil {
+            return errors.New("json.NewDecoder.Decode(): " + err.Error())
+        }
+        
+        for _, x := range items {
+            if opts.Max > 0 && n >= opts.Max {
+                return nil
+            }
+            
+            err = fn(x)
+            if err == ErrStop {
+                return nil
+            } else if err != nil {
+                return err
+            }
+            
+            n += 1
+        }
+        
+        next = nextPageUrl(resp.Header.Get("Link"))
+    }
+    
+    return nil
+}
+
+/*
+ * Extract the url with the relation "next" out of a Link header like
+ * <https://api.github.com/gists?page=2>; rel="next", <...>; rel="last"
+ */
+func nextPageUrl(link string) string {
+    for _, x := range strings.Split(link, ",") {
+        parts := strings.Split(x, ";")
+        
+        for _, y := range parts[1:] {
+            if strings.TrimSpace(y) != `rel="next"` {
+                continue
+            }
+            
+            url := strings.TrimSpace(parts[0])
+            
+            return strings.TrimSuffix(strings.TrimPrefix(url, "<"), ">")
+        }
+    }
+    
+    return ""
+}
+
 func decodeGist(data io.Reader) (*Gist, error) {
     gist := Gist{}
     
@@ -212,15 +893,16 @@ func decodeGist(data io.Reader) (*Gist, error) {
     return &gist, nil
 }
 
-func (this *GistAPI) uploadLocalGist(gist *localGist) (*Gist, error) {
+func (this *GistAPI) uploadLocalGist(ctx context.Context, 
+                                     gist *localGist) (*Gist, error) {
     msg_data, err := json.Marshal(gist)
     if err != nil {
         return nil, errors.New("json.Marshal(): " + err.Error())
     }
     
-    url := "https://api.github.com/gists"
+    url := this.apiUrl + "/gists"
 
-    resp, err := this.getResponse("POST", url, msg_data)
+    resp, err := this.getResponse(ctx, "POST", url, msg_data)
     if err != nil {
         return nil, err
     }
@@ -229,49 +911,45 @@ func (this *GistAPI) uploadLocalGist(gist *localGist) (*Gist, error) {
     
     /* 201 - Created */
     if resp.StatusCode != 201 {
-        /* 422 - Unprocessable Entity */
-        if resp.StatusCode == 422 {
-            return nil, handleMessageUnprocessableEntity(resp.Body)
-        }
-        
-        return nil, errors.New(fmt.Sprintf("Server returned: %s", resp.Status))
+        return nil, newApiError(resp)
     }
     
     return decodeGist(resp.Body)
 }
 
-func handleMessageUnprocessableEntity(data io.Reader) error {
-    errorStruct := struct {
-        Message string          `json:"message"`
-        Errors []struct {
-            Resource string            `json:"resource"`
-            Field string                `json:"field"`
-            Code string                 `json:"code"`
-        }                       `json:"errors"`
-    } {}
-    
-    err := json.NewDecoder(data).Decode(&errorStruct)
-    if err != nil {
-        return errors.New("Decoding error message failed with: " + err.Error())
+func (this *GistAPI) ensureIsGistId(s string) string {
+    for _, x := range []string{ this.htmlUrl, this.apiUrl + "/gists" } {
+        if strings.HasPrefix(s, x + "/") {
+            s = strings.TrimRight(s, "/")
+            
+            return s[strings.LastIndex(s, "/") + 1:]
+        }
     }
     
-    errMsg := fmt.Sprintf("Server returned: %s\n", errorStruct.Message)
-    
-    length := len(errorStruct.Errors)
+    return s
+}
+
+/*
+ * Wait for the given duration unless ctx is done before.
+ */
+func sleep(ctx context.Context, d time.Duration) error {
+    timer := time.NewTimer(d)
+    defer timer.Stop()
     
-    for i, x := range errorStruct.Errors {
-        errMsg += fmt.Sprintf("Error (%d / %d):\n", i + 1, length)
-        errMsg += fmt.Sprintf("  Resource: %s\n  Field: %s\n  Code: %s\n",
-                              x.Resource, x.Field, x.Code)
+    select {
+    case <-timer.C:
+        return nil
+    case <-ctx.Done():
+        return ctx.Err()
     }
-    
-    return errors.New(errMsg)
 }
 
-func ensureIsGistId(s string) string {
-    if strings.Contains(s, "https://gist.github.com/") {
-        return s[strings.LastIndex(s, "/") + 1:]
+func normalizeBaseUrl(url string, fallback string) string {
+    url = strings.TrimRight(strings.TrimSpace(url), "/")
+    
+    if len(url) == 0 {
+        return fallback
     }
     
-    return s
+    return url
 }
\ No newline at end of file
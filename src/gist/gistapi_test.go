@@ -0,0 +1,383 @@
+/*
+ * Copyright (C) 2014  Steffen Nüssle
+ * ggist - go gist
+ *
+ * This file is part of ggist.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package gist_test
+
+import (
+    "encoding/json"
+    "errors"
+    "gist"
+    "gisttest"
+    "io/ioutil"
+    "net/http"
+    "net/http/httptest"
+    "path/filepath"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+const testToken = "tok123"
+
+func newTestApi(t *testing.T) (*gisttest.Server, *gist.GistAPI) {
+    server := gisttest.NewServer()
+    
+    err := server.Start("")
+    if err != nil {
+        t.Fatal(err)
+    }
+    
+    t.Cleanup(func() { server.Close() })
+    
+    server.AddUser(testToken, "octocat")
+    
+    api := gist.NewGistAPI(gist.WithBaseUrl(server.Url()), 
+                           gist.WithHtmlUrl(server.Url()), 
+                           gist.WithToken(testToken))
+    
+    return server, api
+}
+
+func createGist(t *testing.T, 
+                api *gist.GistAPI, 
+                name string, 
+                content string) *gist.Gist {
+    info := gist.SimpleGistInfo{"test gist", true, name, []byte(content)}
+    
+    created, err := api.CreateSimpleGist(&info)
+    if err != nil {
+        t.Fatalf("CreateSimpleGist(): %v", err)
+    }
+    
+    return created
+}
+
+func TestCreateGist(t *testing.T) {
+    _, api := newTestApi(t)
+    
+    name := filepath.Join(t.TempDir(), "hello.go")
+    
+    err := ioutil.WriteFile(name, []byte("package main\n"), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    
+    info := gist.GistInfo{"hello", false, []string{name}}
+    
+    created, err := api.CreateGist(&info)
+    if err != nil {
+        t.Fatalf("CreateGist(): %v", err)
+    }
+    
+    if len(created.Id) == 0 || created.Public || 
+       created.Description != "hello" {
+        t.Errorf("Created gist %q, public %v, description %q", 
+                 created.Id, created.Public, created.Description)
+    }
+    
+    if created.Owner == nil || created.Owner.Login != "octocat" {
+        t.Errorf("Owner = %v", created.Owner)
+    }
+    
+    file, ok := created.Files["hello.go"]
+    if !ok || file.Content != "package main\n" {
+        t.Errorf("Files = %v", created.Files)
+    }
+}
+
+func TestGetGist(t *testing.T) {
+    _, api := newTestApi(t)
+    
+    created := createGist(t, api, "a.txt", "a\n")
+    
+    /* Urls are accepted as well as plain ids */
+    for _, x := range []string{created.Id, created.Url} {
+        received, err := api.GetGist(x)
+        if err != nil {
+            t.Fatalf("GetGist(%q): %v", x, err)
+        }
+        
+        if received.Id != created.Id || received.Files["a.txt"] == nil || 
+           received.Files["a.txt"].Content != "a\n" {
+            t.Errorf("GetGist(%q) = %v", x, received)
+        }
+    }
+    
+    _, err := api.GetGist("fa00000000000000000000000000ffff")
+    
+    notFound := &gist.NotFoundError{}
+    if !errors.As(err, &notFound) {
+        t.Errorf("GetGist() of an unknown gist returned %v", err)
+    }
+}
+
+func TestUpdateGist(t *testing.T) {
+    _, api := newTestApi(t)
+    
+    created := createGist(t, api, "a.txt", "a\n")
+    
+    name := filepath.Join(t.TempDir(), "b.txt")
+    
+    err := ioutil.WriteFile(name, []byte("b\n"), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    
+    info := gist.GistUpdateInfo{"changed", 
+                                []string{name}, 
+                                map[string]string{ "a.txt" : "c.txt" }, 
+                                nil}
+    
+    updated, err := api.UpdateGist(created.Id, &info)
+    if err != nil {
+        t.Fatalf("UpdateGist(): %v", err)
+    }
+    
+    if updated.Description != "changed" || len(updated.Files) != 2 || 
+       updated.Files["b.txt"] == nil || updated.Files["c.txt"] == nil {
+        t.Errorf("Gist after update: %q %v", 
+                 updated.Description, updated.Files)
+    }
+    
+    info = gist.GistUpdateInfo{"", nil, nil, []string{"b.txt"}}
+    
+    updated, err = api.UpdateGist(created.Id, &info)
+    if err != nil {
+        t.Fatalf("UpdateGist(): %v", err)
+    }
+    
+    if len(updated.Files) != 1 || updated.Files["c.txt"] == nil {
+        t.Errorf("Files after delete: %v", updated.Files)
+    }
+}
+
+func TestDeleteGist(t *testing.T) {
+    _, api := newTestApi(t)
+    
+    created := createGist(t, api, "a.txt", "a\n")
+    
+    err := api.DeleteGist(created.Id)
+    if err != nil {
+        t.Fatalf("DeleteGist(): %v", err)
+    }
+    
+    _, err = api.GetGist(created.Id)
+    
+    notFound := &gist.NotFoundError{}
+    if !errors.As(err, &notFound) {
+        t.Errorf("GetGist() of a deleted gist returned %v", err)
+    }
+}
+
+func TestListPagination(t *testing.T) {
+    _, api := newTestApi(t)
+    
+    for i := 0; i < 5; i++ {
+        createGist(t, api, "a.txt", "a\n")
+    }
+    
+    gists, err := api.ListMyGists(&gist.ListOptions{2, 0, time.Time{}})
+    if err != nil {
+        t.Fatalf("ListMyGists(): %v", err)
+    }
+    
+    if len(gists) != 5 {
+        t.Errorf("Listed %d gists in pages of 2, want 5", len(gists))
+    }
+    
+    gists, err = api.ListMyGists(&gist.ListOptions{2, 3, time.Time{}})
+    if err != nil {
+        t.Fatalf("ListMyGists(): %v", err)
+    }
+    
+    if len(gists) != 3 {
+        t.Errorf("Listed %d gists with a limit of 3", len(gists))
+    }
+    
+    n := 0
+    
+    err = api.ForEachMyGist(nil, func(x *gist.Gist) error {
+        n += 1
+        return gist.ErrStop
+    })
+    
+    if err != nil || n != 1 {
+        t.Errorf("ForEachMyGist() returned %v after %d gists", err, n)
+    }
+}
+
+func TestValidationFailed(t *testing.T) {
+    _, api := newTestApi(t)
+    
+    info := gist.SimpleGistInfo{"empty", true, "empty.txt", []byte{}}
+    
+    _, err := api.CreateSimpleGist(&info)
+    
+    validation := &gist.ValidationError{}
+    if !errors.As(err, &validation) {
+        t.Fatalf("CreateSimpleGist() of an empty file returned %v", err)
+    }
+    
+    if len(validation.Errors) != 1 || validation.Errors[0].Field != "files" {
+        t.Errorf("Errors = %v", validation.Errors)
+    }
+}
+
+func TestRateLimitExceeded(t *testing.T) {
+    server, api := newTestApi(t)
+    
+    server.SetRateLimit(2)
+    api.SetRateLimitPolicy(gist.RateLimitFail)
+    
+    created := createGist(t, api, "a.txt", "a\n")
+    
+    _, err := api.GetGist(created.Id)
+    if err != nil {
+        t.Fatalf("GetGist(): %v", err)
+    }
+    
+    limit := api.LastRateLimit()
+    if limit == nil || limit.Limit != 2 || limit.Remaining != 0 {
+        t.Errorf("LastRateLimit() = %v", limit)
+    }
+    
+    _, err = api.GetGist(created.Id)
+    
+    rateLimited := &gist.RateLimitError{}
+    if !errors.As(err, &rateLimited) {
+        t.Fatalf("GetGist() beyond the rate limit returned %v", err)
+    }
+    
+    if rateLimited.Reset.Before(time.Now()) {
+        t.Errorf("Reset = %v lies in the past", rateLimited.Reset)
+    }
+}
+
+/*
+ * Truncated files are completed from their raw url. The token must not
+ * be sent along if the raw url points to another host than the API.
+ */
+func TestRawContentCredentials(t *testing.T) {
+    mutex := sync.Mutex{}
+    headers := make(map[string]http.Header)
+    
+    record := func(w http.ResponseWriter, r *http.Request) {
+        mutex.Lock()
+        headers[r.URL.Path] = r.Header.Clone()
+        mutex.Unlock()
+        
+        w.Write([]byte("content of " + r.URL.Path))
+    }
+    
+    foreign := httptest.NewServer(http.HandlerFunc(record))
+    defer foreign.Close()
+    
+    var server *httptest.Server
+    
+    server = httptest.NewServer(http.HandlerFunc(
+        func(w http.ResponseWriter, r *http.Request) {
+            if r.URL.Path != "/gists/fa01" {
+                record(w, r)
+                return
+            }
+            
+            json.NewEncoder(w).Encode(map[string]interface{}{
+                "id"    : "fa01", 
+                "files" : map[string]interface{}{
+                    "a.txt" : map[string]interface{}{
+                        "filename"  : "a.txt", 
+                        "truncated" : true, 
+                        "raw_url"   : foreign.URL + "/raw/a.txt", 
+                    }, 
+                    "b.txt" : map[string]interface{}{
+                        "filename"  : "b.txt", 
+                        "truncated" : true, 
+                        "raw_url"   : server.URL + "/raw/b.txt", 
+                    }, 
+                }, 
+            })
+        }))
+    defer server.Close()
+    
+    api := gist.NewGistAPI(gist.WithBaseUrl(server.URL), 
+                           gist.WithToken(testToken))
+    
+    received, err := api.GetGist("fa01")
+    if err != nil {
+        t.Fatalf("GetGist(): %v", err)
+    }
+    
+    if received.Files["a.txt"].Content != "content of /raw/a.txt" {
+        t.Errorf("a.txt = %q", received.Files["a.txt"].Content)
+    }
+    
+    mutex.Lock()
+    defer mutex.Unlock()
+    
+    foreignHeader := headers["/raw/a.txt"]
+    
+    if x := foreignHeader.Get("Authorization"); len(x) > 0 {
+        t.Errorf("Sent %q to a foreign host", x)
+    }
+    
+    if x := foreignHeader.Get("Accept"); strings.Contains(x, "github") {
+        t.Errorf("Sent the API Accept header %q for a raw file", x)
+    }
+    
+    auth := headers["/raw/b.txt"].Get("Authorization")
+    if auth != "token " + testToken {
+        t.Errorf("Sent %q to the API host", auth)
+    }
+}
+
+func TestListForks(t *testing.T) {
+    server, api := newTestApi(t)
+    
+    server.AddUser("tok456", "hubot")
+    
+    other := gist.NewGistAPI(gist.WithBaseUrl(server.Url()), 
+                             gist.WithHtmlUrl(server.Url()), 
+                             gist.WithToken("tok456"))
+    
+    created := createGist(t, api, "a.txt", "a\n")
+    
+    fork, err := other.Fork(created.Id)
+    if err != nil {
+        t.Fatalf("Fork(): %v", err)
+    }
+    
+    forks, err := api.ListForks(created.Id, nil)
+    if err != nil {
+        t.Fatalf("ListForks(): %v", err)
+    }
+    
+    if len(forks) != 1 {
+        t.Fatalf("Listed %d forks, want 1", len(forks))
+    }
+    
+    if forks[0].Owner == nil || forks[0].Owner.Login != "hubot" {
+        t.Errorf("Owner of the fork = %v", forks[0].Owner)
+    }
+    
+    if len(forks[0].Url) == 0 || forks[0].Url != fork.Url {
+        t.Errorf("Url of the fork = %q, want %q", forks[0].Url, fork.Url)
+    }
+}
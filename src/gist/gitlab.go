@@ -0,0 +1,608 @@
+/*
+ * Copyright (C) 2014  Steffen Nüssle
+ * ggist - go gist
+ *
+ * This file is part of ggist.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+
+package gist
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+const DefaultGitLabUrl = "https://gitlab.com/api/v4"
+
+/*
+ * GitLab stores gists as personal snippets. The description of a gist
+ * becomes the title of the snippet and secret gists become private
+ * snippets. Like GistAPI all methods come as Xxx and XxxContext.
+ */
+type GitLab struct {
+    client http.Client
+    token string
+    userAgent string
+    apiUrl string
+}
+
+type gitLabUser struct {
+    Id int64                        `json:"id"`
+    Username string                 `json:"username"`
+    WebUrl string                   `json:"web_url"`
+    AvatarUrl string                `json:"avatar_url"`
+}
+
+type gitLabFile struct {
+    Path string                     `json:"path"`
+    RawUrl string                   `json:"raw_url"`
+}
+
+type gitLabSnippet struct {
+    Id int64                        `json:"id"`
+    Title string                    `json:"title"`
+    Visibility string               `json:"visibility"`
+    Author gitLabUser               `json:"author"`
+    CreatedAt time.Time             `json:"created_at"`
+    UpdatedAt time.Time             `json:"updated_at"`
+    WebUrl string                   `json:"web_url"`
+    HttpUrl string                  `json:"http_url_to_repo"`
+    SshUrl string                   `json:"ssh_url_to_repo"`
+    Files []gitLabFile              `json:"files"`
+}
+
+type gitLabFileAction struct {
+    Action string                   `json:"action,omitempty"`
+    FilePath string                 `json:"file_path"`
+    PreviousPath string             `json:"previous_path,omitempty"`
+    Content *string                 `json:"content,omitempty"`
+}
+
+type gitLabSnippetUpdate struct {
+    Title string                    `json:"title,omitempty"`
+    Visibility string               `json:"visibility,omitempty"`
+    Files []gitLabFileAction        `json:"files,omitempty"`
+}
+
+func NewGitLab() *GitLab {
+    gitLab := GitLab{}
+    gitLab.userAgent = DefaultUserAgent
+    gitLab.apiUrl    = DefaultGitLabUrl
+    
+    return &gitLab
+}
+
+/*
+ * Set the personal access token which needs the "api" scope.
+ */
+func (this *GitLab) SetToken(token string) {
+    this.token = strings.TrimSpace(token)
+}
+
+/*
+ * Set the base url of the REST API, e.g. "https://gitlab.example.com/api/v4".
+ * An empty url restores the default.
+ */
+func (this *GitLab) SetApiUrl(url string) {
+    this.apiUrl = normalizeBaseUrl(url, DefaultGitLabUrl)
+}
+
+func (this *GitLab) ApiUrl() string {
+    return this.apiUrl
+}
+
+func (this *GitLab) SetUserAgent(userAgent string) {
+    this.userAgent = strings.TrimSpace(userAgent)
+    
+    if len(this.userAgent) == 0 {
+        this.userAgent = DefaultUserAgent
+    }
+}
+
+func (this *GitLab) SetTimeout(timeout time.Duration) {
+    this.client.Timeout = timeout
+}
+
+/*
+ * Extract the id of a snippet from urls like
+ * "https://gitlab.example.com/-/snippets/42". Anything else is returned
+ * unchanged.
+ */
+func (this *GitLab) ParseGistId(s string) string {
+    index := strings.LastIndex(s, "/snippets/")
+    if index < 0 {
+        return s
+    }
+    
+    id := s[index + len("/snippets/"):]
+    
+    return strings.SplitN(id, "/", 2)[0]
+}
+
+func (this *GitLab) CreateGist(info *GistInfo) (*Gist, error) {
+    return this.CreateGistContext(context.Background(), info)
+}
+
+func (this *GitLab) CreateGistContext(ctx context.Context, 
+                                      info *GistInfo) (*Gist, error) {
+    gist, err := newLocalGist(info.Description, info.Public, &info.Files)
+    if err != nil {
+        return nil, err
+    }
+    
+    return this.uploadLocalGist(ctx, gist)
+}
+
+func (this *GitLab) CreateSimpleGist(info *SimpleGistInfo) (*Gist, error) {
+    return this.CreateSimpleGistContext(context.Background(), info)
+}
+
+func (this *GitLab) CreateSimpleGistContext(
+                                    ctx context.Context, 
+                                    info *SimpleGistInfo) (*Gist, error) {
+    gist := &localGist{info.Description, info.Public, make(map[string]file)}
+    
+    gist.Files[info.FileName] = file{string(info.Data)}
+    
+    return this.uploadLocalGist(ctx, gist)
+}
+
+func (this *GitLab) GetGist(id string) (*Gist, error) {
+    return this.GetGistContext(context.Background(), id)
+}
+
+/*
+ * The server only returns the names of the files of a snippet, so their
+ * content is downloaded one by one.
+ */
+func (this *GitLab) GetGistContext(ctx context.Context, 
+                                   id string) (*Gist, error) {
+    snippet, err := this.getSnippet(ctx, this.ParseGistId(id))
+    if err != nil {
+        return nil, err
+    }
+    
+    gist := snippet.toGist()
+    
+    for _, x := range snippet.Files {
+        content, err := this.getFileContent(ctx, snippet, &x)
+        if err != nil {
+            return nil, fmt.Errorf("Failed to download %s: %w", x.Path, err)
+        }
+        
+        gist.Files[x.Path].Content = content
+        gist.Files[x.Path].Size    = int64(len(content))
+    }
+    
+    return gist, nil
+}
+
+func (this *GitLab) UpdateGist(id string, 
+                               info *GistUpdateInfo) (*Gist, error) {
+    return this.UpdateGistContext(context.Background(), id, info)
+}
+
+/*
+ * Files which do not exist in the snippet yet are added, all others are
+ * replaced. The current file list is fetched first to tell them apart.
+ */
+func (this *GitLab) UpdateGistContext(ctx context.Context, 
+                                      id string, 
+                                      info *GistUpdateInfo) (*Gist, error) {
+    update, err := newLocalGistUpdate(info)
+    if err != nil {
+        return nil, err
+    }
+    
+    id = this.ParseGistId(id)
+    
+    snippet, err := this.getSnippet(ctx, id)
+    if err != nil {
+        return nil, err
+    }
+    
+    exists := make(map[string]bool, len(snippet.Files))
+    for _, x := range snippet.Files {
+        exists[x.Path] = true
+    }
+    
+    names := make([]string, 0, len(update.Files))
+    for key := range update.Files {
+        names = append(names, key)
+    }
+    
+    /* Apply the changes in a stable order */
+    sort.Strings(names)
+    
+    msg := gitLabSnippetUpdate{update.Description, "", nil}
+    
+    for _, x := range names {
+        val := update.Files[x]
+        
+        action := gitLabFileAction{"update", x, "", nil}
+        
+        switch {
+        case val == nil:
+            action.Action = "delete"
+        case val.FileName != nil:
+            action = gitLabFileAction{"move", *val.FileName, x, val.Content}
+        case !exists[x]:
+            action.Action  = "create"
+            action.Content = val.Content
+        default:
+            action.Content = val.Content
+        }
+        
+        msg.Files = append(msg.Files, action)
+    }
+    
+    msg_data, err := json.Marshal(&msg)
+    if err != nil {
+        return nil, errors.New("json.Marshal(): " + err.Error())
+    }
+    
+    url := fmt.Sprintf("%s/snippets/%s", this.apiUrl, id)
+    
+    resp, err := this.doRequest(ctx, "PUT", url, msg_data)
+    if err != nil {
+        return nil, err
+    }
+    
+    defer resp.Body.Close()
+    
+    /* 200 - OK */
+    if resp.StatusCode != 200 {
+        return nil, newApiError(resp)
+    }
+    
+    return decodeSnippet(resp.Body)
+}
+
+func (this *GitLab) DeleteGist(id string) error {
+    return this.DeleteGistContext(context.Background(), id)
+}
+
+func (this *GitLab) DeleteGistContext(ctx context.Context, id string) error {
+    url := fmt.Sprintf("%s/snippets/%s", this.apiUrl, this.ParseGistId(id))
+    
+    resp, err := this.doRequest(ctx, "DELETE", url, nil)
+    if err != nil {
+        return err
+    }
+    
+    defer resp.Body.Close()
+    
+    /* 204: No Content */
+    if resp.StatusCode != 204 {
+        return newApiError(resp)
+    }
+    
+    return nil
+}
+
+func (this *GitLab) ForEachUsersGist(user string, 
+                                     opts *ListOptions, 
+                                     fn func(*Gist) error) error {
+    return this.ForEachUsersGistContext(context.Background(), user, opts, fn)
+}
+
+/*
+ * GitLab cannot list the snippets of a specific user. Returns
+ * ErrNotSupported.
+ */
+func (this *GitLab) ForEachUsersGistContext(ctx context.Context, 
+                                            user string, 
+                                            opts *ListOptions, 
+                                            fn func(*Gist) error) error {
+    return ErrNotSupported
+}
+
+func (this *GitLab) ForEachMyGist(opts *ListOptions, 
+                                  fn func(*Gist) error) error {
+    return this.ForEachMyGistContext(context.Background(), opts, fn)
+}
+
+func (this *GitLab) ForEachMyGistContext(ctx context.Context, 
+                                         opts *ListOptions, 
+                                         fn func(*Gist) error) error {
+    if len(this.token) == 0 {
+        return errors.New("Listing your own snippets requires a token")
+    }
+    
+    return this.forEachSnippet(ctx, this.apiUrl + "/snippets", opts, fn)
+}
+
+func (this *GitLab) GetAuthenticatedUser() (*User, error) {
+    return this.GetAuthenticatedUserContext(context.Background())
+}
+
+func (this *GitLab) GetAuthenticatedUserContext(
+                                    ctx context.Context) (*User, error) {
+    resp, err := this.doRequest(ctx, "GET", this.apiUrl + "/user", nil)
+    if err != nil {
+        return nil, err
+    }
+    
+    defer resp.Body.Close()
+    
+    /* 200 - OK */
+    if resp.StatusCode != 200 {
+        return nil, newApiError(resp)
+    }
+    
+    user := gitLabUser{}
+    
+    err = json.NewDecoder(resp.Body).Decode(&user)
+    if err != nil {
+        return nil, errors.New("json.NewDecoder.Decode(): " + err.Error())
+    }
+    
+    return user.toUser(), nil
+}
+
+func (this *GitLab) getSnippet(ctx context.Context, 
+                               id string) (*gitLabSnippet, error) {
+    url := fmt.Sprintf("%s/snippets/%s", this.apiUrl, id)
+    
+    resp, err := this.doRequest(ctx, "GET", url, nil)
+    if err != nil {
+        return nil, err
+    }
+    
+    defer resp.Body.Close()
+    
+    /* 200 - OK */
+    if resp.StatusCode != 200 {
+        return nil, newApiError(resp)
+    }
+    
+    snippet := gitLabSnippet{}
+    
+    err = json.NewDecoder(resp.Body).Decode(&snippet)
+    if err != nil {
+        return nil, errors.New("json.NewDecoder.Decode(): " + err.Error())
+    }
+    
+    return &snippet, nil
+}
+
+/*
+ * The raw url of a file has the form "<web-url>/raw/<ref>/<path>" but
+ * is not accessible with a token, so only the ref is taken from it.
+ */
+func (this *GitLab) getFileContent(ctx context.Context, 
+                                   snippet *gitLabSnippet, 
+                                   file *gitLabFile) (string, error) {
+    ref := "main"
+    
+    prefix := snippet.WebUrl + "/raw/"
+    if strings.HasPrefix(file.RawUrl, prefix) {
+        ref = strings.SplitN(file.RawUrl[len(prefix):], "/", 2)[0]
+    }
+    
+    path := strings.Replace(url.QueryEscape(file.Path), "+", "%20", -1)
+    
+    rawUrl := fmt.Sprintf("%s/snippets/%d/files/%s/%s/raw", 
+                          this.apiUrl, snippet.Id, url.PathEscape(ref), path)
+    
+    resp, err := this.doRequest(ctx, "GET", rawUrl, nil)
+    if err != nil {
+        return "", err
+    }
+    
+    defer resp.Body.Close()
+    
+    /* 200 - OK */
+    if resp.StatusCode != 200 {
+        return "", newApiError(resp)
+    }
+    
+    builder := strings.Builder{}
+    
+    _, err = io.Copy(&builder, resp.Body)
+    if err != nil {
+        return "", errors.New("io.Copy(): " + err.Error())
+    }
+    
+    return builder.String(), nil
+}
+
+func (this *GitLab) uploadLocalGist(ctx context.Context, 
+                                    gist *localGist) (*Gist, error) {
+    msg := gitLabSnippetUpdate{gist.Description, "private", nil}
+    
+    if gist.Public {
+        msg.Visibility = "public"
+    }
+    
+    names := make([]string, 0, len(gist.Files))
+    for key := range gist.Files {
+        names = append(names, key)
+    }
+    
+    sort.Strings(names)
+    
+    for _, x := range names {
+        content := gist.Files[x].Content
+        
+        msg.Files = append(msg.Files, 
+                           gitLabFileAction{"", x, "", &content})
+    }
+    
+    msg_data, err := json.Marshal(&msg)
+    if err != nil {
+        return nil, errors.New("json.Marshal(): " + err.Error())
+    }
+    
+    resp, err := this.doRequest(ctx, "POST", this.apiUrl + "/snippets", 
+                                msg_data)
+    if err != nil {
+        return nil, err
+    }
+    
+    defer resp.Body.Close()
+    
+    /* 201 - Created */
+    if resp.StatusCode != 201 {
+        return nil, newApiError(resp)
+    }
+    
+    return decodeSnippet(resp.Body)
+}
+
+/*
+ * Snippets are requested page by page. Snippets updated before the since
+ * time of opts are skipped and do not count against its limit.
+ */
+func (this *GitLab) forEachSnippet(ctx context.Context, 
+                                   rawUrl string, 
+                                   opts *ListOptions, 
+                                   fn func(*Gist) error) error {
+    if opts == nil {
+        opts = &ListOptions{}
+    }
+    
+    /* The server does not know the since parameter */
+    pageOpts := ListOptions{opts.PerPage, 0, time.Time{}}
+    
+    next, err := pageOpts.apply(rawUrl)
+    if err != nil {
+        return err
+    }
+    
+    n := 0
+    
+    for len(next) > 0 {
+        resp, err := this.doRequest(ctx, "GET", next, nil)
+        if err != nil {
+            return err
+        }
+        
+        /* 200 - OK */
+        if resp.StatusCode != 200 {
+            defer resp.Body.Close()
+            return newApiError(resp)
+        }
+        
+        snippets := make([]gitLabSnippet, 0, 32)
+        
+        err = json.NewDecoder(resp.Body).Decode(&snippets)
+        resp.Body.Close()
+        if err != nil {
+            return errors.New("json.NewDecoder.Decode(): " + err.Error())
+        }
+        
+        for i := range snippets {
+            x := &snippets[i]
+            
+            if x.UpdatedAt.Before(opts.Since) {
+                continue
+            }
+            
+            if opts.Max > 0 && n >= opts.Max {
+                return nil
+            }
+            
+            err = fn(x.toGist())
+            if err == ErrStop {
+                return nil
+            } else if err != nil {
+                return err
+            }
+            
+            n += 1
+        }
+        
+        next = nextPageUrl(resp.Header.Get("Link"))
+    }
+    
+    return nil
+}
+
+func (this *GitLab) doRequest(ctx context.Context, 
+                              what string, 
+                              url string, 
+                              data []byte) (*http.Response, error) {
+    msg, err := http.NewRequestWithContext(ctx, what, url, 
+                                           bytes.NewReader(data))
+    if err != nil {
+        return nil, err
+    }
+    
+    msg.Header.Set("User-Agent", this.userAgent)
+    
+    if data != nil {
+        msg.Header.Set("Content-Type", "application/json")
+    }
+    
+    if len(this.token) > 0 {
+        msg.Header.Set("PRIVATE-TOKEN", this.token)
+    }
+    
+    resp, err := this.client.Do(msg)
+    if err != nil {
+        return nil, &NetworkError{what, url, err}
+    }
+    
+    return resp, nil
+}
+
+func decodeSnippet(data io.Reader) (*Gist, error) {
+    snippet := gitLabSnippet{}
+    
+    err := json.NewDecoder(data).Decode(&snippet)
+    if err != nil {
+        return nil, errors.New("json.NewDecoder.Decode(): " + err.Error())
+    }
+    
+    return snippet.toGist(), nil
+}
+
+func (this *gitLabUser) toUser() *User {
+    return &User{this.Username, this.Id, this.WebUrl, this.AvatarUrl, "User"}
+}
+
+func (this *gitLabSnippet) toGist() *Gist {
+    gist := Gist{}
+    gist.Url         = this.WebUrl
+    gist.Id          = strconv.FormatInt(this.Id, 10)
+    gist.Description = this.Title
+    gist.Files       = make(map[string]*GistFile, len(this.Files))
+    gist.Public      = this.Visibility == "public"
+    gist.CreatedAt   = this.CreatedAt
+    gist.UpdatedAt   = this.UpdatedAt
+    gist.GitPullUrl  = this.HttpUrl
+    gist.GitPushUrl  = this.SshUrl
+    
+    gist.Owner = this.Author.toUser()
+    
+    for _, x := range this.Files {
+        gist.Files[x.Path] = &GistFile{x.Path, "", 0, "", "", x.RawUrl, false}
+    }
+    
+    return &gist
+}
@@ -0,0 +1,443 @@
+/*
+ * Copyright (C) 2014  Steffen Nüssle
+ * ggist - go gist
+ *
+ * This file is part of ggist.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package gist_test
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "gist"
+    "net/http"
+    "net/http/httptest"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+const fakeGitLabToken = "glpat-test"
+
+type fakeSnippet struct {
+    id int64
+    title string
+    visibility string
+    files map[string]string
+}
+
+type fakeFileAction struct {
+    Action string                   `json:"action"`
+    FilePath string                 `json:"file_path"`
+    PreviousPath string             `json:"previous_path"`
+    Content *string                 `json:"content"`
+}
+
+type fakeSnippetUpdate struct {
+    Title string                    `json:"title"`
+    Visibility string               `json:"visibility"`
+    Files []fakeFileAction          `json:"files"`
+}
+
+/*
+ * Just enough of the snippets API of GitLab to exercise the GitLab
+ * backend. Snippets are listed with their ids in ascending order.
+ */
+type fakeGitLab struct {
+    mutex sync.Mutex
+    server *httptest.Server
+    snippets map[int64]*fakeSnippet
+    nextId int64
+}
+
+func newFakeGitLab(t *testing.T) (*fakeGitLab, *gist.GitLab) {
+    fake := &fakeGitLab{}
+    fake.snippets = make(map[int64]*fakeSnippet)
+    fake.nextId   = 1
+    fake.server   = httptest.NewServer(fake)
+    
+    t.Cleanup(fake.server.Close)
+    
+    gitLab := gist.NewGitLab()
+    gitLab.SetApiUrl(fake.server.URL + "/api/v4")
+    gitLab.SetToken(fakeGitLabToken)
+    
+    return fake, gitLab
+}
+
+func (this *fakeGitLab) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+    
+    if r.Header.Get("PRIVATE-TOKEN") != fakeGitLabToken {
+        this.writeError(w, 401, "401 Unauthorized")
+        return
+    }
+    
+    path := strings.TrimPrefix(r.URL.Path, "/api/v4/snippets")
+    parts := strings.Split(strings.Trim(path, "/"), "/")
+    
+    if len(path) == 0 {
+        switch r.Method {
+        case "GET":
+            this.serveList(w, r)
+        case "POST":
+            this.serveCreate(w, r)
+        default:
+            this.writeError(w, 405, "405 Method Not Allowed")
+        }
+        
+        return
+    }
+    
+    id, err := strconv.ParseInt(parts[0], 10, 64)
+    snippet, ok := this.snippets[id]
+    if err != nil || !ok {
+        this.writeError(w, 404, "404 Snippet Not Found")
+        return
+    }
+    
+    /* files/<ref>/<path>/raw */
+    if len(parts) == 5 && parts[1] == "files" && parts[4] == "raw" {
+        content, ok := snippet.files[parts[3]]
+        if !ok {
+            this.writeError(w, 404, "404 File Not Found")
+            return
+        }
+        
+        w.Write([]byte(content))
+        return
+    }
+    
+    switch r.Method {
+    case "GET":
+        this.writeJson(w, 200, this.toJson(snippet))
+    case "PUT":
+        this.serveUpdate(w, r, snippet)
+    case "DELETE":
+        delete(this.snippets, id)
+        w.WriteHeader(204)
+    default:
+        this.writeError(w, 405, "405 Method Not Allowed")
+    }
+}
+
+func (this *fakeGitLab) serveList(w http.ResponseWriter, r *http.Request) {
+    ids := make([]int64, 0, len(this.snippets))
+    for key := range this.snippets {
+        ids = append(ids, key)
+    }
+    
+    sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+    
+    perPage, _ := strconv.Atoi(r.URL.Query().Get("per_page"))
+    if perPage <= 0 {
+        perPage = 20
+    }
+    
+    page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+    if page <= 0 {
+        page = 1
+    }
+    
+    begin := (page - 1) * perPage
+    if begin > len(ids) {
+        begin = len(ids)
+    }
+    
+    end := begin + perPage
+    if end < len(ids) {
+        w.Header().Set("Link", fmt.Sprintf(
+            "<%s/api/v4/snippets?per_page=%d&page=%d>; rel=\"next\"", 
+            this.server.URL, perPage, page + 1))
+    } else {
+        end = len(ids)
+    }
+    
+    list := make([]interface{}, 0, end - begin)
+    
+    for _, x := range ids[begin:end] {
+        list = append(list, this.toJson(this.snippets[x]))
+    }
+    
+    this.writeJson(w, 200, list)
+}
+
+func (this *fakeGitLab) serveCreate(w http.ResponseWriter, r *http.Request) {
+    msg := fakeSnippetUpdate{}
+    
+    err := json.NewDecoder(r.Body).Decode(&msg)
+    if err != nil || len(msg.Title) == 0 || len(msg.Files) == 0 {
+        this.writeError(w, 400, "title or files are missing")
+        return
+    }
+    
+    snippet := &fakeSnippet{this.nextId, 
+                            msg.Title, 
+                            msg.Visibility, 
+                            make(map[string]string)}
+    
+    for _, x := range msg.Files {
+        snippet.files[x.FilePath] = *x.Content
+    }
+    
+    this.snippets[snippet.id] = snippet
+    this.nextId += 1
+    
+    this.writeJson(w, 201, this.toJson(snippet))
+}
+
+func (this *fakeGitLab) serveUpdate(w http.ResponseWriter, 
+                                    r *http.Request, 
+                                    snippet *fakeSnippet) {
+    msg := fakeSnippetUpdate{}
+    
+    err := json.NewDecoder(r.Body).Decode(&msg)
+    if err != nil {
+        this.writeError(w, 400, err.Error())
+        return
+    }
+    
+    if len(msg.Title) > 0 {
+        snippet.title = msg.Title
+    }
+    
+    for _, x := range msg.Files {
+        switch x.Action {
+        case "create", "update":
+            snippet.files[x.FilePath] = *x.Content
+        case "delete":
+            delete(snippet.files, x.FilePath)
+        case "move":
+            content := snippet.files[x.PreviousPath]
+            if x.Content != nil {
+                content = *x.Content
+            }
+            
+            delete(snippet.files, x.PreviousPath)
+            snippet.files[x.FilePath] = content
+        default:
+            this.writeError(w, 400, "invalid action " + x.Action)
+            return
+        }
+    }
+    
+    this.writeJson(w, 200, this.toJson(snippet))
+}
+
+func (this *fakeGitLab) toJson(snippet *fakeSnippet) interface{} {
+    webUrl := fmt.Sprintf("%s/-/snippets/%d", this.server.URL, snippet.id)
+    files := make([]interface{}, 0, len(snippet.files))
+    
+    for key := range snippet.files {
+        files = append(files, map[string]string{
+            "path"    : key, 
+            "raw_url" : webUrl + "/raw/main/" + key, 
+        })
+    }
+    
+    date := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).
+        Add(time.Duration(snippet.id) * time.Hour)
+    
+    return map[string]interface{}{
+        "id"         : snippet.id, 
+        "title"      : snippet.title, 
+        "visibility" : snippet.visibility, 
+        "author"     : map[string]string{ "username" : "tanuki" }, 
+        "created_at" : date, 
+        "updated_at" : date, 
+        "web_url"    : webUrl, 
+        "files"      : files, 
+    }
+}
+
+func (this *fakeGitLab) writeJson(w http.ResponseWriter, 
+                                  status int, 
+                                  data interface{}) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(data)
+}
+
+func (this *fakeGitLab) writeError(w http.ResponseWriter, 
+                                   status int, 
+                                   msg string) {
+    this.writeJson(w, status, map[string]string{ "message" : msg })
+}
+
+func createSnippet(t *testing.T, 
+                   gitLab *gist.GitLab, 
+                   name string, 
+                   content string) *gist.Gist {
+    info := gist.SimpleGistInfo{"test snippet", true, name, []byte(content)}
+    
+    created, err := gitLab.CreateSimpleGist(&info)
+    if err != nil {
+        t.Fatalf("CreateSimpleGist(): %v", err)
+    }
+    
+    return created
+}
+
+func TestGitLabParseGistId(t *testing.T) {
+    gitLab := gist.NewGitLab()
+    
+    tests := map[string]string{
+        "42"                                          : "42", 
+        "https://gitlab.com/-/snippets/42"            : "42", 
+        "https://gitlab.com/-/snippets/42/raw/main/x" : "42", 
+        "https://gitlab.com/group/p/-/snippets/7"     : "7", 
+    }
+    
+    for key, val := range tests {
+        if id := gitLab.ParseGistId(key); id != val {
+            t.Errorf("ParseGistId(%q) = %q, want %q", key, id, val)
+        }
+    }
+}
+
+func TestGitLabCreateAndGet(t *testing.T) {
+    _, gitLab := newFakeGitLab(t)
+    
+    created := createSnippet(t, gitLab, "hello.txt", "hello, world\n")
+    
+    if created.Id != "1" || !created.Public {
+        t.Errorf("Created snippet %q, public %v", created.Id, created.Public)
+    }
+    
+    received, err := gitLab.GetGist(created.Url)
+    if err != nil {
+        t.Fatalf("GetGist(): %v", err)
+    }
+    
+    if received.Description != "test snippet" {
+        t.Errorf("Description = %q", received.Description)
+    }
+    
+    file, ok := received.Files["hello.txt"]
+    if !ok || file.Content != "hello, world\n" {
+        t.Errorf("Files = %v", received.Files)
+    }
+}
+
+func TestGitLabUpdate(t *testing.T) {
+    fake, gitLab := newFakeGitLab(t)
+    
+    created := createSnippet(t, gitLab, "old.txt", "content\n")
+    
+    info := gist.GistUpdateInfo{"new title", 
+                                nil, 
+                                map[string]string{ "old.txt" : "new.txt" }, 
+                                nil}
+    
+    updated, err := gitLab.UpdateGist(created.Id, &info)
+    if err != nil {
+        t.Fatalf("UpdateGist(): %v", err)
+    }
+    
+    if updated.Description != "new title" {
+        t.Errorf("Description = %q", updated.Description)
+    }
+    
+    files := fake.snippets[1].files
+    if len(files) != 1 || files["new.txt"] != "content\n" {
+        t.Errorf("Files after rename: %v", files)
+    }
+    
+    info = gist.GistUpdateInfo{"", nil, nil, []string{"new.txt"}}
+    
+    _, err = gitLab.UpdateGist(created.Id, &info)
+    if err != nil {
+        t.Fatalf("UpdateGist(): %v", err)
+    }
+    
+    if len(fake.snippets[1].files) != 0 {
+        t.Errorf("Files after delete: %v", fake.snippets[1].files)
+    }
+}
+
+func TestGitLabDelete(t *testing.T) {
+    _, gitLab := newFakeGitLab(t)
+    
+    created := createSnippet(t, gitLab, "x.txt", "x\n")
+    
+    err := gitLab.DeleteGist(created.Id)
+    if err != nil {
+        t.Fatalf("DeleteGist(): %v", err)
+    }
+    
+    _, err = gitLab.GetGist(created.Id)
+    
+    notFound := &gist.NotFoundError{}
+    if !errors.As(err, &notFound) {
+        t.Errorf("GetGist() of a deleted snippet returned %v", err)
+    }
+}
+
+func TestGitLabForEachMyGist(t *testing.T) {
+    _, gitLab := newFakeGitLab(t)
+    
+    for i := 0; i < 5; i++ {
+        createSnippet(t, gitLab, fmt.Sprintf("f%d.txt", i), "x\n")
+    }
+    
+    ids := make([]string, 0, 5)
+    opts := gist.ListOptions{2, 4, time.Time{}}
+    
+    err := gitLab.ForEachMyGist(&opts, func(x *gist.Gist) error {
+        ids = append(ids, x.Id)
+        return nil
+    })
+    
+    if err != nil {
+        t.Fatalf("ForEachMyGist(): %v", err)
+    }
+    
+    if strings.Join(ids, ",") != "1,2,3,4" {
+        t.Errorf("Listed %v, want the first four snippets", ids)
+    }
+}
+
+func TestGitLabForEachUsersGist(t *testing.T) {
+    _, gitLab := newFakeGitLab(t)
+    
+    err := gitLab.ForEachUsersGist("tanuki", nil, func(x *gist.Gist) error {
+        t.Errorf("Unexpected snippet %s", x.Id)
+        return nil
+    })
+    
+    if err != gist.ErrNotSupported {
+        t.Errorf("ForEachUsersGist() returned %v", err)
+    }
+}
+
+func TestGitLabUnauthorized(t *testing.T) {
+    _, gitLab := newFakeGitLab(t)
+    
+    gitLab.SetToken("wrong")
+    
+    _, err := gitLab.GetGist("1")
+    
+    unauthorized := &gist.UnauthorizedError{}
+    if !errors.As(err, &unauthorized) {
+        t.Errorf("GetGist() with a wrong token returned %v", err)
+    }
+}
@@ -18,26 +18,123 @@
  * along with this program.  If not, see <http://www.gnu.org/licenses/>.
  */
 
+
 package gist
 
 import (
+    "bufio"
     "bytes"
     "errors"
     "encoding/csv"
+    "encoding/json"
     "fmt"
+    "io/ioutil"
     "os"
+    "regexp"
+    "sort"
     "strings"
+    "sync"
+    "time"
+)
+
+/*
+ * The history file starts with a header line carrying the format version
+ * followed by one JSON object per line. Version 1 was a CSV file with the
+ * id, description and visibility of each gist; it is converted
+ * automatically and kept as "<path>.v1".
+ */
+const HistoryVersion = 2
+
+/* Actions recorded in the history */
+const (
+    ActionCreated = "created"
+    ActionUpdated = "updated"
+    ActionFetched = "fetched"
+    ActionForked  = "forked"
 )
 
-type gistTuple struct {
-    id string
-    description string
+type HistoryEntry struct {
+    Id string                       `json:"id"`
+    Url string                      `json:"url,omitempty"`
+    Description string              `json:"description"`
+    Visibility string               `json:"visibility,omitempty"`
+    Files []string                  `json:"files,omitempty"`
+    Action string                   `json:"action,omitempty"`
+    Account string                  `json:"account,omitempty"`
+    CreatedAt *time.Time            `json:"created_at,omitempty"`
+    UpdatedAt *time.Time            `json:"updated_at,omitempty"`
+    RecordedAt *time.Time           `json:"recorded_at,omitempty"`
+}
+
+/*
+ * Criteria for History.Search(). Pattern is matched against the id, the
+ * description and the file names of an entry; nil matches every entry.
+ * Since and Until restrict the time an entry was recorded, zero values
+ * disable the bound. Limit keeps only the most recent matches.
+ */
+type HistoryFilter struct {
+    Pattern *regexp.Regexp
+    Since time.Time
+    Until time.Time
+    CreatedOnly bool
+    Limit int
+}
+
+/*
+ * A search result together with the index GetGistIdAt() accepts.
+ */
+type HistoryMatch struct {
+    Index int
+    Entry HistoryEntry
+}
+
+type historyHeader struct {
+    Version int                     `json:"version"`
+}
+
+func newHistoryEntry(gist *Gist, action string, account string) HistoryEntry {
+    visibility := "secret"
+    if gist.Public {
+        visibility = "public"
+    }
+    
+    files := make([]string, 0, len(gist.Files))
+    for key := range gist.Files {
+        files = append(files, key)
+    }
+    
+    sort.Strings(files)
+    
+    entry := HistoryEntry{}
+    entry.Id          = gist.Id
+    entry.Url         = gist.Url
+    entry.Description = gist.Description
+    entry.Visibility  = visibility
+    entry.Files       = files
+    entry.Action      = action
+    entry.Account     = account
+    entry.CreatedAt   = timeOrNil(gist.CreatedAt)
+    entry.UpdatedAt   = timeOrNil(gist.UpdatedAt)
+    entry.RecordedAt  = timeOrNil(time.Now().UTC().Truncate(time.Second))
+    
+    return entry
+}
+
+func (this *HistoryEntry) line() (string, error) {
+    data, err := json.Marshal(this)
+    if err != nil {
+        return "", errors.New("json.Marshal(): " + err.Error())
+    }
+    
+    return string(data) + "\n", nil
 }
 
 type History struct {
     path string
-    gists []gistTuple
+    gists []HistoryEntry
     file os.File
+    mutex sync.Mutex
+    account string
 }
 
 func NewHistory(path string) (*History, error) {
@@ -62,67 +159,367 @@ func NewHistory(path string) (*History, error) {
         }
     }
     
-    history := History{path, make([]gistTuple, 0, 100), *file}
+    history := History{path, make([]HistoryEntry, 0, 100), *file, 
+                       sync.Mutex{}, ""}
+    
+    data, err := ioutil.ReadAll(file)
+    if err != nil {
+        return nil, errors.New("Failed to read " + path + ": " + err.Error())
+    }
+    
+    data = bytes.TrimSpace(data)
+    
+    switch {
+    case len(data) == 0:
+        err = history.rewrite()
+    case data[0] == '{':
+        err = history.parse(data)
+    default:
+        err = history.migrate(data)
+    }
     
-    stat, err := history.file.Stat()
     if err != nil {
         return nil, err
     }
     
-    size := stat.Size()
+    return &history, nil
+}
+
+func (this *History) parse(data []byte) error {
+    scanner := bufio.NewScanner(bytes.NewReader(data))
+    scanner.Buffer(make([]byte, 64 * 1024), len(data) + 1)
     
-    if size != 0 {
-        buf := make([]byte, size)
-        
-        n, err := history.file.Read(buf)
-        switch {
-        case err != nil:
-            return nil, err
-        case int64(n) != size:
-            return nil, errors.New("Failed to read complete file " + path)
+    header := historyHeader{}
+    
+    for i := 1; scanner.Scan(); i++ {
+        line := bytes.TrimSpace(scanner.Bytes())
+        if len(line) == 0 {
+            continue
         }
         
-        reader := bytes.NewReader(buf)
+        var err error
+        
+        if i == 1 {
+            err = json.Unmarshal(line, &header)
+        } else {
+            entry := HistoryEntry{}
+            
+            err = json.Unmarshal(line, &entry)
+            if err == nil && len(entry.Id) == 0 {
+                err = errors.New("missing id")
+            }
+            
+            this.gists = append(this.gists, entry)
+        }
         
-        all, err := csv.NewReader(reader).ReadAll()
         if err != nil {
-            return nil, err
+            msg := fmt.Sprintf("Invalid history file %s:%d: %s", 
+                               this.path, i, err.Error())
+            return errors.New(msg)
+        }
+    }
+    
+    if header.Version < 2 || header.Version > HistoryVersion {
+        msg := fmt.Sprintf("Unsupported version %d of history file %s", 
+                           header.Version, this.path)
+        return errors.New(msg)
+    }
+    
+    return scanner.Err()
+}
+
+/*
+ * Convert a version 1 history. The old file is kept as backup.
+ */
+func (this *History) migrate(data []byte) error {
+    csvReader := csv.NewReader(bytes.NewReader(data))
+    
+    /*
+     * Older entries do not record the visibility of the gist and some
+     * versions wrote descriptions without quoting them.
+     */
+    csvReader.FieldsPerRecord = -1
+    csvReader.LazyQuotes      = true
+    
+    all, err := csvReader.ReadAll()
+    if err != nil {
+        msg := "Unable to convert history file " + this.path + ": " + 
+               err.Error() + ". Manually fix or remove it."
+        return errors.New(msg)
+    }
+    
+    for _, x := range all {
+        if len(x) < 2 {
+            msg := "Invalid history file. Manually fix or remove " + this.path
+            return errors.New(msg)
         }
         
-        for _, x := range all {
-            if len(x) != 2 {
-                msg := "Invalid history file. Manually fix or remove " + path
-                return nil, errors.New(msg)
-            }
+        this.gists = append(this.gists, parseHistoryRecord(x))
+    }
+    
+    err = ioutil.WriteFile(this.path + ".v1", data, 0644)
+    if err != nil {
+        return errors.New("Failed to back up history: " + err.Error())
+    }
+    
+    return this.rewrite()
+}
 
-            history.gists = append(history.gists, gistTuple{x[0], x[1]})
+func (this *HistoryMatch) String() string {
+    return fmt.Sprintf("%4d <> %s %-6s : %s", this.Index, this.Entry.Id, 
+                       this.Entry.Visibility, this.Entry.Description)
+}
+
+func (this *HistoryEntry) matches(filter *HistoryFilter) bool {
+    if filter.CreatedOnly && this.Action != ActionCreated {
+        return false
+    }
+    
+    if !filter.Since.IsZero() || !filter.Until.IsZero() {
+        /* Converted entries do not know when they were recorded */
+        if this.RecordedAt == nil {
+            return false
+        }
+        
+        if this.RecordedAt.Before(filter.Since) {
+            return false
+        }
+        
+        if !filter.Until.IsZero() && this.RecordedAt.After(filter.Until) {
+            return false
         }
     }
     
-    return &history, nil
+    if filter.Pattern == nil {
+        return true
+    }
+    
+    if filter.Pattern.MatchString(this.Id) || 
+       filter.Pattern.MatchString(this.Description) {
+        return true
+    }
+    
+    for _, x := range this.Files {
+        if filter.Pattern.MatchString(x) {
+            return true
+        }
+    }
+    
+    return false
+}
+
+/*
+ * Version 1 wrote "id,description[,visibility]" without escaping the
+ * description, so a description containing commas spans several fields.
+ * The last field is only the visibility if it is exactly "public" or
+ * "secret", everything between it and the id is the description.
+ */
+func parseHistoryRecord(fields []string) HistoryEntry {
+    entry := HistoryEntry{}
+    entry.Id = fields[0]
+    
+    fields = fields[1:]
+    
+    last := fields[len(fields) - 1]
+    
+    if len(fields) > 1 && (last == "public" || last == "secret") {
+        entry.Visibility = last
+        fields = fields[:len(fields) - 1]
+    }
+    
+    entry.Description = strings.Join(fields, ",")
+    
+    return entry
 }
 
 func (this *History) String() string {
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+    
     ret := ""
     
     length := len(this.gists)
     
     for i, x := range this.gists {
-        ret += fmt.Sprintf("%4d <> %s : %s\n", length - i, x.id, x.description)
+        match := HistoryMatch{length - i, x}
+        
+        ret += match.String() + "\n"
+    }
+    
+    if len(ret) == 0 {
+        return ret
     }
     
     /* Do not return last newline */
     return ret[:len(ret) - 1]
 }
 
+/*
+ * Return the entries accepted by filter, oldest first, like String()
+ * lists them.
+ */
+func (this *History) Search(filter *HistoryFilter) []HistoryMatch {
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+    
+    length := len(this.gists)
+    
+    matches := make([]HistoryMatch, 0, 32)
+    
+    for i, x := range this.gists {
+        if x.matches(filter) {
+            matches = append(matches, HistoryMatch{length - i, x})
+        }
+    }
+    
+    if filter.Limit > 0 && len(matches) > filter.Limit {
+        matches = matches[len(matches) - filter.Limit:]
+    }
+    
+    return matches
+}
+
+func (this *History) Len() int {
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+    
+    return len(this.gists)
+}
+
 func (this *History) GetGistIdAt(i int) string {
-    return this.gists[len(this.gists) - i].id
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+    
+    return this.gists[len(this.gists) - i].Id
 }
 
-func (this *History) AddGist(gist *Gist) {
-    if this.gists[len(this.gists) - 1].id != gist.Id {
+/*
+ * Set the login of the authenticated user, which is recorded as the
+ * account of new entries.
+ */
+func (this *History) SetAccount(login string) {
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+    
+    this.account = login
+}
+
+/*
+ * Record that action was performed on gist. Repeating the action of the
+ * last entry on the same gist refreshes that entry instead of adding one.
+ */
+func (this *History) AddGist(gist *Gist, action string) error {
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+    
+    entry := newHistoryEntry(gist, action, this.account)
+    
+    length := len(this.gists)
+    
+    if length > 0 && this.gists[length - 1].Id == gist.Id && 
+       this.gists[length - 1].Action == action {
+        this.gists[length - 1] = entry
         
-        this.gists = append(this.gists, gistTuple{gist.Id, gist.Description})
-        this.file.WriteString(fmt.Sprintf("%s,%s\n", gist.Id, gist.Description))
+        return this.rewrite()
     }
+    
+    line, err := entry.line()
+    if err != nil {
+        return err
+    }
+    
+    _, err = this.file.WriteString(line)
+    if err != nil {
+        return errors.New("Failed to write history: " + err.Error())
+    }
+    
+    this.gists = append(this.gists, entry)
+    
+    return nil
+}
+
+/*
+ * Report whether a public gist was ever created according to the history.
+ * Fetched gists of other users and converted entries, whose origin is
+ * unknown, do not count.
+ */
+func (this *History) HasPublicGist() bool {
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+    
+    for _, x := range this.gists {
+        if x.Action == ActionCreated && x.Visibility == "public" {
+            return true
+        }
+    }
+    
+    return false
+}
+
+/*
+ * Remove all entries of the gist with the given id and rewrite the
+ * history file. Returns the number of removed entries.
+ */
+func (this *History) RemoveGist(id string) (int, error) {
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+    
+    gists := make([]HistoryEntry, 0, len(this.gists))
+    
+    for _, x := range this.gists {
+        if x.Id != id {
+            gists = append(gists, x)
+        }
+    }
+    
+    n := len(this.gists) - len(gists)
+    if n == 0 {
+        return 0, nil
+    }
+    
+    this.gists = gists
+    
+    return n, this.rewrite()
+}
+
+func (this *History) rewrite() error {
+    header, err := json.Marshal(&historyHeader{HistoryVersion})
+    if err != nil {
+        return errors.New("json.Marshal(): " + err.Error())
+    }
+    
+    buf := bytes.Buffer{}
+    buf.Write(header)
+    buf.WriteString("\n")
+    
+    for _, x := range this.gists {
+        line, err := x.line()
+        if err != nil {
+            return err
+        }
+        
+        buf.WriteString(line)
+    }
+    
+    err = this.file.Truncate(0)
+    if err != nil {
+        return errors.New("os.File.Truncate() failed with: " + err.Error())
+    }
+    
+    _, err = this.file.Seek(0, 0)
+    if err != nil {
+        return errors.New("os.File.Seek() failed with: " + err.Error())
+    }
+    
+    _, err = this.file.Write(buf.Bytes())
+    
+    return err
+}
+
+func timeOrNil(t time.Time) *time.Time {
+    if t.IsZero() {
+        return nil
+    }
+    
+    return &t
 }
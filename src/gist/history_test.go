@@ -0,0 +1,170 @@
+/*
+ * Copyright (C) 2014  Steffen Nüssle
+ * ggist - go gist
+ *
+ * This file is part of ggist.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package gist_test
+
+import (
+    "gist"
+    "io/ioutil"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestHistoryMigrate(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "history")
+    
+    v1 := "abc,hello, world\n" + 
+          "abd,say \"hi\" now\n" + 
+          "abe,a,b,c\n" + 
+          "abf,quoted,public\n" + 
+          "abg,\"with, comma\",secret\n"
+    
+    err := ioutil.WriteFile(path, []byte(v1), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    
+    history, err := gist.NewHistory(path)
+    if err != nil {
+        t.Fatalf("NewHistory(): %v", err)
+    }
+    
+    tests := map[string][2]string{
+        "abc" : { "hello, world", "" }, 
+        "abd" : { "say \"hi\" now", "" }, 
+        "abe" : { "a,b,c", "" }, 
+        "abf" : { "quoted", "public" }, 
+        "abg" : { "with, comma", "secret" }, 
+    }
+    
+    matches := history.Search(&gist.HistoryFilter{})
+    if len(matches) != len(tests) {
+        t.Fatalf("Converted %d entries, want %d", len(matches), len(tests))
+    }
+    
+    for _, x := range matches {
+        want := tests[x.Entry.Id]
+        
+        if x.Entry.Description != want[0] || x.Entry.Visibility != want[1] {
+            t.Errorf("Entry %s: description %q, visibility %q, want %q, %q", 
+                     x.Entry.Id, x.Entry.Description, x.Entry.Visibility, 
+                     want[0], want[1])
+        }
+    }
+    
+    backup, err := ioutil.ReadFile(path + ".v1")
+    if err != nil || string(backup) != strings.TrimSpace(v1) {
+        t.Errorf("Backup of the old history: %q, %v", backup, err)
+    }
+    
+    /* The converted history is read back unchanged */
+    history, err = gist.NewHistory(path)
+    if err != nil {
+        t.Fatalf("NewHistory(): %v", err)
+    }
+    
+    if history.Len() != len(tests) {
+        t.Errorf("Reloaded %d entries, want %d", history.Len(), len(tests))
+    }
+}
+
+func TestHistoryHasPublicGist(t *testing.T) {
+    history, err := gist.NewHistory(filepath.Join(t.TempDir(), "history"))
+    if err != nil {
+        t.Fatalf("NewHistory(): %v", err)
+    }
+    
+    received := &gist.Gist{Id : "fa01", Public : true}
+    
+    history.AddGist(received, gist.ActionFetched)
+    
+    /* Fetching a public gist of someone else must not skip the prompt */
+    if history.HasPublicGist() {
+        t.Errorf("HasPublicGist() is true after fetching a public gist")
+    }
+    
+    created := &gist.Gist{Id : "fa02", Public : true}
+    
+    history.AddGist(created, gist.ActionCreated)
+    
+    if !history.HasPublicGist() {
+        t.Errorf("HasPublicGist() is false after creating a public gist")
+    }
+}
+
+func TestHistoryAddGist(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "history")
+    
+    history, err := gist.NewHistory(path)
+    if err != nil {
+        t.Fatalf("NewHistory(): %v", err)
+    }
+    
+    history.SetAccount("octocat")
+    
+    owner := &gist.User{Login : "hubot"}
+    
+    steps := []struct {
+        action string
+        description string
+    }{
+        { gist.ActionFetched, "fetched" }, 
+        { gist.ActionCreated, "original" }, 
+        { gist.ActionUpdated, "renamed" }, 
+        { gist.ActionUpdated, "renamed again" }, 
+    }
+    
+    for _, x := range steps {
+        received := &gist.Gist{Id : "fa01", 
+                               Description : x.description, 
+                               Owner : owner}
+        
+        err = history.AddGist(received, x.action)
+        if err != nil {
+            t.Fatalf("AddGist(): %v", err)
+        }
+    }
+    
+    /* The repeated update refreshes the last entry */
+    history, err = gist.NewHistory(path)
+    if err != nil {
+        t.Fatalf("NewHistory(): %v", err)
+    }
+    
+    matches := history.Search(&gist.HistoryFilter{})
+    if len(matches) != 3 {
+        t.Fatalf("History has %d entries, want 3", len(matches))
+    }
+    
+    latest := matches[len(matches) - 1].Entry
+    
+    if latest.Action != gist.ActionUpdated || 
+       latest.Description != "renamed again" {
+        t.Errorf("Latest entry: %q %q", latest.Action, latest.Description)
+    }
+    
+    for _, x := range matches {
+        if x.Entry.Account != "octocat" {
+            t.Errorf("Entry %d records account %q, want the authenticated "+ 
+                     "user", x.Index, x.Entry.Account)
+        }
+    }
+}
@@ -0,0 +1,71 @@
+/*
+ * Copyright (C) 2014  Steffen Nüssle
+ * ggist - go gist
+ *
+ * This file is part of ggist.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package gist
+
+import (
+    "errors"
+    "net/url"
+    "strconv"
+    "time"
+)
+
+/*
+ * Callbacks of the ForEach* functions may return ErrStop to end a listing
+ * early without causing an error.
+ */
+var ErrStop = errors.New("Stop listing")
+
+/*
+ * Options for endpoints returning lists. PerPage sets the number of items
+ * requested per page, Max limits the total number of items. Zero values
+ * select the server default and no limit respectively. If Since is set,
+ * endpoints listing gists only return gists updated after that time.
+ */
+type ListOptions struct {
+    PerPage int
+    Max int
+    Since time.Time
+}
+
+func (this *ListOptions) apply(rawUrl string) (string, error) {
+    if this.PerPage <= 0 && this.Since.IsZero() {
+        return rawUrl, nil
+    }
+    
+    u, err := url.Parse(rawUrl)
+    if err != nil {
+        return "", errors.New("url.Parse(): " + err.Error())
+    }
+    
+    query := u.Query()
+    
+    if this.PerPage > 0 {
+        query.Set("per_page", strconv.Itoa(this.PerPage))
+    }
+    
+    if !this.Since.IsZero() {
+        query.Set("since", this.Since.UTC().Format(time.RFC3339))
+    }
+    
+    u.RawQuery = query.Encode()
+    
+    return u.String(), nil
+}
@@ -0,0 +1,554 @@
+/*
+ * Copyright (C) 2014  Steffen Nüssle
+ * ggist - go gist
+ *
+ * This file is part of ggist.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+
+package gist
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "io/ioutil"
+    "os"
+    "os/user"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+const localMetaFile = "gist.json"
+
+/*
+ * LocalStore keeps gists in directories below a root directory without
+ * talking to any server. Each gist directory holds a gist.json file with
+ * the metadata and a "files" directory with the contents:
+ *
+ *     <root>/<id>/gist.json
+ *     <root>/<id>/files/<name>
+ *
+ * The context arguments only exist to satisfy the Backend interface.
+ */
+type LocalStore struct {
+    root string
+    mutex sync.Mutex
+}
+
+type localMeta struct {
+    Id string                       `json:"id"`
+    Description string              `json:"description"`
+    Public bool                     `json:"public"`
+    Owner string                    `json:"owner"`
+    CreatedAt time.Time             `json:"created_at"`
+    UpdatedAt time.Time             `json:"updated_at"`
+    Files []string                  `json:"files"`
+}
+
+/*
+ * Create a store keeping its gists below root. The directory is created
+ * if necessary.
+ */
+func NewLocalStore(root string) (*LocalStore, error) {
+    err := os.MkdirAll(root, 0755)
+    if err != nil {
+        return nil, errors.New("os.MkdirAll() failed with: " + err.Error())
+    }
+    
+    return &LocalStore{root, sync.Mutex{}}, nil
+}
+
+func (this *LocalStore) Root() string {
+    return this.root
+}
+
+/*
+ * Gists in a local directory belong to the user running the program.
+ */
+func (this *LocalStore) GetAuthenticatedUserContext(
+                                    ctx context.Context) (*User, error) {
+    return &User{currentUserName(), 0, "", "", "User"}, nil
+}
+
+/*
+ * Gists are identified by the name of their directory. Paths of gist
+ * directories are accepted as well.
+ */
+func (this *LocalStore) ParseGistId(s string) string {
+    s = strings.TrimPrefix(s, "file://")
+    
+    if strings.HasPrefix(s, this.root + "/") {
+        s = strings.TrimRight(s, "/")
+        
+        return s[strings.LastIndex(s, "/") + 1:]
+    }
+    
+    return s
+}
+
+func (this *LocalStore) CreateGistContext(ctx context.Context, 
+                                          info *GistInfo) (*Gist, error) {
+    gist, err := newLocalGist(info.Description, info.Public, &info.Files)
+    if err != nil {
+        return nil, err
+    }
+    
+    return this.storeLocalGist(gist)
+}
+
+func (this *LocalStore) CreateSimpleGistContext(
+                                    ctx context.Context, 
+                                    info *SimpleGistInfo) (*Gist, error) {
+    gist := &localGist{info.Description, info.Public, make(map[string]file)}
+    
+    gist.Files[info.FileName] = file{string(info.Data)}
+    
+    return this.storeLocalGist(gist)
+}
+
+func (this *LocalStore) GetGistContext(ctx context.Context, 
+                                       id string) (*Gist, error) {
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+    
+    meta, err := this.readMeta(this.ParseGistId(id))
+    if err != nil {
+        return nil, err
+    }
+    
+    return this.readGist(meta)
+}
+
+func (this *LocalStore) UpdateGistContext(ctx context.Context, 
+                                          id string, 
+                                          info *GistUpdateInfo) (*Gist, error) {
+    update, err := newLocalGistUpdate(info)
+    if err != nil {
+        return nil, err
+    }
+    
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+    
+    meta, err := this.readMeta(this.ParseGistId(id))
+    if err != nil {
+        return nil, err
+    }
+    
+    files := make(map[string]bool, len(meta.Files))
+    for _, x := range meta.Files {
+        files[x] = true
+    }
+    
+    names := make([]string, 0, len(update.Files))
+    for key := range update.Files {
+        names = append(names, key)
+    }
+    
+    /* Apply the changes in a stable order */
+    sort.Strings(names)
+    
+    /* Check all changes first, so a rejected update changes nothing */
+    for _, key := range names {
+        val := update.Files[key]
+        
+        if val == nil || val.FileName != nil || val.Content == nil {
+            if !files[key] {
+                return nil, newLocalError(422, "File " + key + " not found")
+            }
+        }
+        
+        switch {
+        case val == nil:
+            delete(files, key)
+        case val.FileName != nil:
+            name := *val.FileName
+            
+            if !isValidFileName(name) {
+                msg := "Invalid file name \"" + name + "\""
+                return nil, newLocalError(422, msg)
+            }
+            
+            if name != key && files[name] {
+                msg := "File " + name + " already exists"
+                return nil, newLocalError(422, msg)
+            }
+            
+            delete(files, key)
+            files[name] = true
+        default:
+            files[key] = true
+        }
+    }
+    
+    dir := filepath.Join(this.root, meta.Id, "files")
+    
+    for _, key := range names {
+        val := update.Files[key]
+        name := key
+        
+        switch {
+        case val == nil:
+            err = os.Remove(filepath.Join(dir, key))
+        case val.FileName != nil:
+            name = *val.FileName
+            err = os.Rename(filepath.Join(dir, key), filepath.Join(dir, name))
+        }
+        
+        if err != nil {
+            return nil, errors.New("Failed to update " + key + ": " + 
+                                   err.Error())
+        }
+        
+        if val != nil && val.Content != nil {
+            err = writeFileAtomic(filepath.Join(dir, name), 
+                                  []byte(*val.Content))
+            if err != nil {
+                return nil, err
+            }
+        }
+    }
+    
+    if len(update.Description) > 0 {
+        meta.Description = update.Description
+    }
+    
+    meta.Files = meta.Files[:0]
+    for key := range files {
+        meta.Files = append(meta.Files, key)
+    }
+    
+    sort.Strings(meta.Files)
+    
+    meta.UpdatedAt = time.Now().UTC()
+    
+    err = this.writeMeta(meta)
+    if err != nil {
+        return nil, err
+    }
+    
+    return this.readGist(meta)
+}
+
+func (this *LocalStore) DeleteGistContext(ctx context.Context, 
+                                          id string) error {
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+    
+    meta, err := this.readMeta(this.ParseGistId(id))
+    if err != nil {
+        return err
+    }
+    
+    err = os.RemoveAll(filepath.Join(this.root, meta.Id))
+    if err != nil {
+        return errors.New("os.RemoveAll() failed with: " + err.Error())
+    }
+    
+    return nil
+}
+
+/*
+ * Every gist records the login name of the user who created it.
+ */
+func (this *LocalStore) ForEachUsersGistContext(ctx context.Context, 
+                                                user string, 
+                                                opts *ListOptions, 
+                                                fn func(*Gist) error) error {
+    return this.forEachGist(opts, func(meta *localMeta) bool {
+        return meta.Owner == user
+    }, fn)
+}
+
+func (this *LocalStore) ForEachMyGistContext(ctx context.Context, 
+                                             opts *ListOptions, 
+                                             fn func(*Gist) error) error {
+    return this.forEachGist(opts, func(meta *localMeta) bool {
+        return true
+    }, fn)
+}
+
+/*
+ * Gists are passed to fn in the order the server would list them: the
+ * most recently updated gist first.
+ */
+func (this *LocalStore) forEachGist(opts *ListOptions, 
+                                    filter func(*localMeta) bool, 
+                                    fn func(*Gist) error) error {
+    if opts == nil {
+        opts = &ListOptions{}
+    }
+    
+    this.mutex.Lock()
+    
+    entries, err := ioutil.ReadDir(this.root)
+    if err != nil {
+        this.mutex.Unlock()
+        return errors.New("ioutil.ReadDir(): " + err.Error())
+    }
+    
+    gists := make([]*Gist, 0, len(entries))
+    
+    for _, x := range entries {
+        if !x.IsDir() {
+            continue
+        }
+        
+        var notFound *NotFoundError
+        
+        /* Skip directories which are not gists */
+        meta, err := this.readMeta(x.Name())
+        if errors.As(err, &notFound) {
+            continue
+        } else if err != nil {
+            this.mutex.Unlock()
+            return err
+        }
+        
+        if !filter(meta) || meta.UpdatedAt.Before(opts.Since) {
+            continue
+        }
+        
+        gist, err := this.readGist(meta)
+        if err != nil {
+            this.mutex.Unlock()
+            return err
+        }
+        
+        gists = append(gists, gist)
+    }
+    
+    /* Do not hold the lock while fn runs, it may modify the store */
+    this.mutex.Unlock()
+    
+    sort.SliceStable(gists, func(i, j int) bool {
+        return gists[i].UpdatedAt.After(gists[j].UpdatedAt)
+    })
+    
+    for i, x := range gists {
+        if opts.Max > 0 && i >= opts.Max {
+            break
+        }
+        
+        err = fn(x)
+        if err == ErrStop {
+            return nil
+        } else if err != nil {
+            return err
+        }
+    }
+    
+    return nil
+}
+
+func (this *LocalStore) storeLocalGist(gist *localGist) (*Gist, error) {
+    id, err := newLocalId()
+    if err != nil {
+        return nil, err
+    }
+    
+    now := time.Now().UTC()
+    
+    meta := localMeta{id, gist.Description, gist.Public, currentUserName(), 
+                      now, now, make([]string, 0, len(gist.Files))}
+    
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+    
+    dir := filepath.Join(this.root, id, "files")
+    
+    err = os.MkdirAll(dir, 0755)
+    if err != nil {
+        return nil, errors.New("os.MkdirAll() failed with: " + err.Error())
+    }
+    
+    for key, val := range gist.Files {
+        if !isValidFileName(key) {
+            os.RemoveAll(filepath.Join(this.root, id))
+            return nil, newLocalError(422, "Invalid file name \"" + key + "\"")
+        }
+        
+        err = writeFileAtomic(filepath.Join(dir, key), []byte(val.Content))
+        if err != nil {
+            os.RemoveAll(filepath.Join(this.root, id))
+            return nil, err
+        }
+        
+        meta.Files = append(meta.Files, key)
+    }
+    
+    sort.Strings(meta.Files)
+    
+    err = this.writeMeta(&meta)
+    if err != nil {
+        os.RemoveAll(filepath.Join(this.root, id))
+        return nil, err
+    }
+    
+    return this.readGist(&meta)
+}
+
+func (this *LocalStore) readMeta(id string) (*localMeta, error) {
+    if !isValidFileName(id) {
+        return nil, newLocalError(404, "Gist " + id + " not found")
+    }
+    
+    data, err := ioutil.ReadFile(filepath.Join(this.root, id, localMetaFile))
+    if os.IsNotExist(err) {
+        return nil, newLocalError(404, "Gist " + id + " not found")
+    } else if err != nil {
+        return nil, errors.New("ioutil.ReadFile(): " + err.Error())
+    }
+    
+    meta := localMeta{}
+    
+    err = json.Unmarshal(data, &meta)
+    if err != nil {
+        return nil, errors.New("Invalid metadata of gist " + id + ": " + 
+                               err.Error())
+    }
+    
+    meta.Id = id
+    
+    return &meta, nil
+}
+
+func (this *LocalStore) writeMeta(meta *localMeta) error {
+    data, err := json.MarshalIndent(meta, "", "    ")
+    if err != nil {
+        return errors.New("json.MarshalIndent(): " + err.Error())
+    }
+    
+    path := filepath.Join(this.root, meta.Id, localMetaFile)
+    
+    return writeFileAtomic(path, append(data, '\n'))
+}
+
+func (this *LocalStore) readGist(meta *localMeta) (*Gist, error) {
+    dir := filepath.Join(this.root, meta.Id)
+    
+    gist := Gist{}
+    gist.Url         = "file://" + dir
+    gist.Id          = meta.Id
+    gist.Description = meta.Description
+    gist.Files       = make(map[string]*GistFile, len(meta.Files))
+    gist.Public      = meta.Public
+    gist.CreatedAt   = meta.CreatedAt
+    gist.UpdatedAt   = meta.UpdatedAt
+    
+    if len(meta.Owner) > 0 {
+        gist.Owner = &User{meta.Owner, 0, "", "", "User"}
+    }
+    
+    for _, x := range meta.Files {
+        path := filepath.Join(dir, "files", x)
+        
+        data, err := ioutil.ReadFile(path)
+        if err != nil {
+            return nil, errors.New("ioutil.ReadFile(): " + err.Error())
+        }
+        
+        gist.Files[x] = &GistFile{x, "", int64(len(data)), "", string(data), 
+                                  "file://" + path, false}
+    }
+    
+    return &gist, nil
+}
+
+/*
+ * Ids look like the ones of GitHub: 32 hexadecimal digits.
+ */
+func newLocalId() (string, error) {
+    buf := make([]byte, 16)
+    
+    _, err := rand.Read(buf)
+    if err != nil {
+        return "", errors.New("rand.Read(): " + err.Error())
+    }
+    
+    return hex.EncodeToString(buf), nil
+}
+
+/*
+ * Errors carry the status code the server would have answered with, so
+ * callers can handle all backends alike.
+ */
+func newLocalError(statusCode int, msg string) error {
+    apiError := ApiError{statusCode, "", msg, "", nil}
+    
+    switch statusCode {
+    case 404:
+        apiError.Status = "404 Not Found"
+        return &NotFoundError{apiError}
+    case 422:
+        apiError.Status = "422 Unprocessable Entity"
+        return &ValidationError{apiError}
+    }
+    
+    return &apiError
+}
+
+func isValidFileName(name string) bool {
+    return len(name) > 0 && name != "." && name != ".." && 
+           !strings.ContainsAny(name, "/\\")
+}
+
+func currentUserName() string {
+    current, err := user.Current()
+    if err != nil {
+        return os.Getenv("USER")
+    }
+    
+    return current.Username
+}
+
+/*
+ * Write to a temporary file first so readers never see partial data.
+ */
+func writeFileAtomic(path string, data []byte) error {
+    file, err := ioutil.TempFile(filepath.Dir(path), ".tmp-*")
+    if err != nil {
+        return errors.New("ioutil.TempFile(): " + err.Error())
+    }
+    
+    _, err = file.Write(data)
+    if err == nil {
+        err = file.Close()
+    } else {
+        file.Close()
+    }
+    
+    if err == nil {
+        err = os.Chmod(file.Name(), 0644)
+    }
+    
+    if err == nil {
+        err = os.Rename(file.Name(), path)
+    }
+    
+    if err != nil {
+        os.Remove(file.Name())
+        return errors.New("Failed to write " + path + ": " + err.Error())
+    }
+    
+    return nil
+}
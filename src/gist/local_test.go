@@ -0,0 +1,123 @@
+/*
+ * Copyright (C) 2014  Steffen Nüssle
+ * ggist - go gist
+ *
+ * This file is part of ggist.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package gist_test
+
+import (
+    "context"
+    "errors"
+    "gist"
+    "io/ioutil"
+    "path/filepath"
+    "testing"
+)
+
+func newLocalGist(t *testing.T, 
+                  store *gist.LocalStore, 
+                  files map[string]string) *gist.Gist {
+    dir := t.TempDir()
+    names := make([]string, 0, len(files))
+    
+    for key, val := range files {
+        name := filepath.Join(dir, key)
+        
+        err := ioutil.WriteFile(name, []byte(val), 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+        
+        names = append(names, name)
+    }
+    
+    info := gist.GistInfo{"local", false, names}
+    
+    created, err := store.CreateGistContext(context.Background(), &info)
+    if err != nil {
+        t.Fatalf("CreateGist(): %v", err)
+    }
+    
+    return created
+}
+
+func TestLocalStoreRename(t *testing.T) {
+    ctx := context.Background()
+    
+    store, err := gist.NewLocalStore(t.TempDir())
+    if err != nil {
+        t.Fatal(err)
+    }
+    
+    created := newLocalGist(t, store, map[string]string{
+        "a.txt" : "a\n", 
+        "b.txt" : "b\n", 
+    })
+    
+    info := gist.GistUpdateInfo{"", 
+                                nil, 
+                                map[string]string{ "a.txt" : "c.txt" }, 
+                                []string{"b.txt"}}
+    
+    updated, err := store.UpdateGistContext(ctx, created.Id, &info)
+    if err != nil {
+        t.Fatalf("UpdateGist(): %v", err)
+    }
+    
+    file, ok := updated.Files["c.txt"]
+    if len(updated.Files) != 1 || !ok || file.Content != "a\n" {
+        t.Errorf("Files after update: %v", updated.Files)
+    }
+}
+
+func TestLocalStoreRenameOntoExistingFile(t *testing.T) {
+    ctx := context.Background()
+    
+    store, err := gist.NewLocalStore(t.TempDir())
+    if err != nil {
+        t.Fatal(err)
+    }
+    
+    created := newLocalGist(t, store, map[string]string{
+        "a.txt" : "a\n", 
+        "b.txt" : "b\n", 
+    })
+    
+    info := gist.GistUpdateInfo{"changed", 
+                                nil, 
+                                map[string]string{ "a.txt" : "b.txt" }, 
+                                nil}
+    
+    _, err = store.UpdateGistContext(ctx, created.Id, &info)
+    
+    validation := &gist.ValidationError{}
+    if !errors.As(err, &validation) {
+        t.Fatalf("UpdateGist() returned %v, want a validation error", err)
+    }
+    
+    received, err := store.GetGistContext(ctx, created.Id)
+    if err != nil {
+        t.Fatalf("GetGist(): %v", err)
+    }
+    
+    if received.Description != "local" || 
+       received.Files["a.txt"].Content != "a\n" || 
+       received.Files["b.txt"].Content != "b\n" {
+        t.Errorf("Rejected update changed the gist: %v", received.Files)
+    }
+}
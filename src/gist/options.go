@@ -0,0 +1,109 @@
+/*
+ * Copyright (C) 2014  Steffen Nüssle
+ * ggist - go gist
+ *
+ * This file is part of ggist.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+
+package gist
+
+import (
+    "net/http"
+    "strings"
+)
+
+/*
+ * GitHub rejects requests without a User-Agent header.
+ */
+const DefaultUserAgent = "ggist"
+
+/*
+ * Options configure a GistAPI when passed to NewGistAPI(). They are
+ * applied in the given order, so WithTransport() after WithHttpClient()
+ * replaces the transport of the passed client.
+ */
+type Option func(api *GistAPI)
+
+/*
+ * Use a copy of client for all requests. This allows to set up proxies,
+ * custom CA bundles, cookie jars or timeouts.
+ */
+func WithHttpClient(client *http.Client) Option {
+    return func(api *GistAPI) {
+        api.client = *client
+    }
+}
+
+/*
+ * Send all requests through transport, e.g. a transport recording the
+ * traffic for tests.
+ */
+func WithTransport(transport http.RoundTripper) Option {
+    return func(api *GistAPI) {
+        api.client.Transport = transport
+    }
+}
+
+/*
+ * Same as SetApiUrl().
+ */
+func WithBaseUrl(url string) Option {
+    return func(api *GistAPI) {
+        api.SetApiUrl(url)
+    }
+}
+
+/*
+ * Same as SetHtmlUrl().
+ */
+func WithHtmlUrl(url string) Option {
+    return func(api *GistAPI) {
+        api.SetHtmlUrl(url)
+    }
+}
+
+/*
+ * Same as SetToken().
+ */
+func WithToken(token string) Option {
+    return func(api *GistAPI) {
+        api.SetToken(token)
+    }
+}
+
+/*
+ * Same as SetCache().
+ */
+func WithCache(cache *Cache) Option {
+    return func(api *GistAPI) {
+        api.SetCache(cache)
+    }
+}
+
+/*
+ * Set the User-Agent header sent with every request. An empty string
+ * restores DefaultUserAgent.
+ */
+func WithUserAgent(userAgent string) Option {
+    return func(api *GistAPI) {
+        api.userAgent = strings.TrimSpace(userAgent)
+        
+        if len(api.userAgent) == 0 {
+            api.userAgent = DefaultUserAgent
+        }
+    }
+}
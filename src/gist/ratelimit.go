@@ -0,0 +1,256 @@
+/*
+ * Copyright (C) 2014  Steffen Nüssle
+ * ggist - go gist
+ *
+ * This file is part of ggist.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package gist
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "math/rand"
+    "net/http"
+    "strconv"
+    "time"
+)
+
+/*
+ * Decides what happens if a request would exceed the rate limit: either
+ * wait until the quota is refilled or fail right away with a
+ * RateLimitError.
+ */
+type RateLimitPolicy int
+
+const (
+    RateLimitWait RateLimitPolicy = iota
+    RateLimitFail
+)
+
+const (
+    DefaultMaxRetries = 4
+    
+    backoffBase = 1 * time.Second
+    backoffMax  = 60 * time.Second
+)
+
+/*
+ * The request quota as reported by the server.
+ */
+type RateLimit struct {
+    Limit int
+    Remaining int
+    Used int
+    Reset time.Time
+}
+
+func (this *GistAPI) SetRateLimitPolicy(policy RateLimitPolicy) {
+    this.rateLimitPolicy = policy
+}
+
+/*
+ * Set how often failed requests are retried after server errors or
+ * secondary rate limits. Server errors are only retried for GET, HEAD,
+ * PUT and DELETE requests. Zero disables retries.
+ */
+func (this *GistAPI) SetMaxRetries(n int) {
+    this.maxRetries = n
+}
+
+/*
+ * Return the quota as seen in the last response or nil if no request was
+ * made so far.
+ */
+func (this *GistAPI) LastRateLimit() *RateLimit {
+    this.rateLimitMutex.Lock()
+    defer this.rateLimitMutex.Unlock()
+    
+    return this.rateLimit
+}
+
+/*
+ * Query the current quota of the core API. This request does not count
+ * against the rate limit itself.
+ */
+func (this *GistAPI) GetRateLimit() (*RateLimit, error) {
+    return this.GetRateLimitContext(context.Background())
+}
+
+func (this *GistAPI) GetRateLimitContext(
+                                    ctx context.Context) (*RateLimit, error) {
+    url := this.apiUrl + "/rate_limit"
+    
+    resp, err := this.doRequest(ctx, "GET", url, nil)
+    if err != nil {
+        return nil, err
+    }
+    
+    defer resp.Body.Close()
+    
+    /* 200 - OK */
+    if resp.StatusCode != 200 {
+        return nil, newApiError(resp)
+    }
+    
+    data := struct {
+        Resources struct {
+            Core struct {
+                Limit int           `json:"limit"`
+                Remaining int       `json:"remaining"`
+                Used int            `json:"used"`
+                Reset int64         `json:"reset"`
+            }                       `json:"core"`
+        }                           `json:"resources"`
+    } {}
+    
+    err = json.NewDecoder(resp.Body).Decode(&data)
+    if err != nil {
+        return nil, errors.New("json.NewDecoder.Decode(): " + err.Error())
+    }
+    
+    core := data.Resources.Core
+    
+    rateLimit := RateLimit{}
+    rateLimit.Limit     = core.Limit
+    rateLimit.Remaining = core.Remaining
+    rateLimit.Used      = core.Used
+    rateLimit.Reset     = time.Unix(core.Reset, 0)
+    
+    return &rateLimit, nil
+}
+
+/*
+ * Block until the quota allows another request or fail, depending on the
+ * configured policy.
+ */
+func (this *GistAPI) awaitRateLimit(ctx context.Context) error {
+    rateLimit := this.LastRateLimit()
+    
+    if rateLimit == nil || rateLimit.Remaining > 0 {
+        return nil
+    }
+    
+    delay := time.Until(rateLimit.Reset)
+    if delay <= 0 {
+        return nil
+    }
+    
+    if this.rateLimitPolicy == RateLimitFail {
+        apiError := ApiError{}
+        apiError.StatusCode = 403
+        apiError.Status     = "403 Forbidden"
+        apiError.Message    = "API rate limit exhausted - request not sent"
+        
+        return &RateLimitError{apiError, rateLimit.Reset, 0}
+    }
+    
+    return sleep(ctx, delay + time.Second)
+}
+
+func (this *GistAPI) updateRateLimit(header http.Header) {
+    remaining, err := strconv.Atoi(header.Get("X-RateLimit-Remaining"))
+    if err != nil {
+        return
+    }
+    
+    reset, _ := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64)
+    
+    rateLimit := RateLimit{}
+    rateLimit.Remaining = remaining
+    rateLimit.Limit, _  = strconv.Atoi(header.Get("X-RateLimit-Limit"))
+    rateLimit.Used, _   = strconv.Atoi(header.Get("X-RateLimit-Used"))
+    rateLimit.Reset     = time.Unix(reset, 0)
+    
+    this.rateLimitMutex.Lock()
+    this.rateLimit = &rateLimit
+    this.rateLimitMutex.Unlock()
+}
+
+/*
+ * Decide whether a request is repeated after receiving resp and how long
+ * to wait before doing so.
+ */
+func (this *GistAPI) retryDelay(resp *http.Response, 
+                                attempt int) (time.Duration, bool) {
+    if attempt >= this.maxRetries {
+        return 0, false
+    }
+    
+    switch {
+    case resp.StatusCode >= 500:
+        /*
+         * The server may have applied a POST or PATCH before failing, so
+         * only idempotent requests are repeated.
+         */
+        if !isIdempotent(resp.Request) {
+            return 0, false
+        }
+        
+        return backoff(attempt), true
+    case resp.StatusCode == 403 || resp.StatusCode == 429:
+        if !isRateLimited(resp) {
+            return 0, false
+        }
+        
+        reset, retryAfter := rateLimitDelays(resp.Header)
+        
+        /* Secondary rate limits tell how long to wait */
+        if retryAfter > 0 {
+            return retryAfter, true
+        }
+        
+        /* The primary rate limit is exhausted */
+        if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+            if this.rateLimitPolicy == RateLimitFail || reset.IsZero() {
+                return 0, false
+            }
+            
+            return time.Until(reset) + time.Second, true
+        }
+        
+        return backoff(attempt), true
+    default:
+        return 0, false
+    }
+}
+
+/*
+ * Exponential backoff with jitter: a random delay between half and the
+ * full value of backoffBase * 2^attempt.
+ */
+func backoff(attempt int) time.Duration {
+    delay := backoffBase << uint(attempt)
+    if delay > backoffMax || delay <= 0 {
+        delay = backoffMax
+    }
+    
+    return delay / 2 + time.Duration(rand.Int63n(int64(delay / 2)))
+}
+
+func isIdempotent(req *http.Request) bool {
+    if req == nil {
+        return false
+    }
+    
+    switch req.Method {
+    case "GET", "HEAD", "PUT", "DELETE":
+        return true
+    }
+    
+    return false
+}
@@ -0,0 +1,100 @@
+/*
+ * Copyright (C) 2014  Steffen Nüssle
+ * ggist - go gist
+ *
+ * This file is part of ggist.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package gist
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+)
+
+/*
+ * Return the revisions of a gist, the most recent one first.
+ */
+func (this *GistAPI) ListRevisions(id string, 
+                                   opts *ListOptions) ([]GistRevision, error) {
+    return this.ListRevisionsContext(context.Background(), id, opts)
+}
+
+func (this *GistAPI) ListRevisionsContext(
+                                    ctx context.Context, 
+                                    id string, 
+                                    opts *ListOptions) ([]GistRevision, error) {
+    revisions := make([]GistRevision, 0, 32)
+    
+    fn := func(x *GistRevision) error {
+        revisions = append(revisions, *x)
+        
+        return nil
+    }
+    
+    err := this.ForEachRevisionContext(ctx, id, opts, fn)
+    if err != nil {
+        return nil, err
+    }
+    
+    return revisions, nil
+}
+
+func (this *GistAPI) ForEachRevision(id string, 
+                                     opts *ListOptions, 
+                                     fn func(*GistRevision) error) error {
+    return this.ForEachRevisionContext(context.Background(), id, opts, fn)
+}
+
+func (this *GistAPI) ForEachRevisionContext(
+                                    ctx context.Context, 
+                                    id string, 
+                                    opts *ListOptions, 
+                                    fn func(*GistRevision) error) error {
+    id = this.ensureIsGistId(id)
+    
+    url := fmt.Sprintf("%s/gists/%s/commits", this.apiUrl, id)
+    
+    return this.forEachItem(ctx, url, opts, func(data json.RawMessage) error {
+        revision := GistRevision{}
+        
+        err := json.Unmarshal(data, &revision)
+        if err != nil {
+            return errors.New("json.Unmarshal(): " + err.Error())
+        }
+        
+        return fn(&revision)
+    })
+}
+
+/*
+ * Return the gist as it was at the given revision.
+ */
+func (this *GistAPI) GetGistRevision(id string, sha string) (*Gist, error) {
+    return this.GetGistRevisionContext(context.Background(), id, sha)
+}
+
+func (this *GistAPI) GetGistRevisionContext(ctx context.Context, 
+                                            id string, 
+                                            sha string) (*Gist, error) {
+    id = this.ensureIsGistId(id)
+    
+    url := fmt.Sprintf("%s/gists/%s/%s", this.apiUrl, id, sha)
+    
+    return this.getGist(ctx, url)
+}
@@ -0,0 +1,120 @@
+/*
+ * Copyright (C) 2014  Steffen Nüssle
+ * ggist - go gist
+ *
+ * This file is part of ggist.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package gist
+
+import (
+    "context"
+    "fmt"
+)
+
+func (this *GistAPI) Star(id string) error {
+    return this.StarContext(context.Background(), id)
+}
+
+func (this *GistAPI) StarContext(ctx context.Context, id string) error {
+    return this.setStar(ctx, "PUT", id)
+}
+
+func (this *GistAPI) Unstar(id string) error {
+    return this.UnstarContext(context.Background(), id)
+}
+
+func (this *GistAPI) UnstarContext(ctx context.Context, id string) error {
+    return this.setStar(ctx, "DELETE", id)
+}
+
+func (this *GistAPI) IsStarred(id string) (bool, error) {
+    return this.IsStarredContext(context.Background(), id)
+}
+
+func (this *GistAPI) IsStarredContext(ctx context.Context, 
+                                      id string) (bool, error) {
+    resp, err := this.getResponse(ctx, "GET", this.starUrl(id), nil)
+    if err != nil {
+        return false, err
+    }
+    
+    defer resp.Body.Close()
+    
+    switch resp.StatusCode {
+    /* 204 - No Content */
+    case 204:
+        return true, nil
+    /* 404 - Not Found: the gist exists but is not starred */
+    case 404:
+        return false, nil
+    default:
+        return false, newApiError(resp)
+    }
+}
+
+func (this *GistAPI) ListStarred(opts *ListOptions) ([]Gist, error) {
+    return this.ListStarredContext(context.Background(), opts)
+}
+
+func (this *GistAPI) ListStarredContext(ctx context.Context, 
+                                        opts *ListOptions) ([]Gist, error) {
+    gists := make([]Gist, 0, 32)
+    
+    err := this.ForEachStarredContext(ctx, opts, func(gist *Gist) error {
+        gists = append(gists, *gist)
+        
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    
+    return gists, nil
+}
+
+func (this *GistAPI) ForEachStarred(opts *ListOptions, 
+                                    fn func(*Gist) error) error {
+    return this.ForEachStarredContext(context.Background(), opts, fn)
+}
+
+func (this *GistAPI) ForEachStarredContext(ctx context.Context, 
+                                           opts *ListOptions, 
+                                           fn func(*Gist) error) error {
+    return this.forEachGist(ctx, this.apiUrl + "/gists/starred", opts, fn)
+}
+
+func (this *GistAPI) setStar(ctx context.Context, 
+                             what string, 
+                             id string) error {
+    resp, err := this.getResponse(ctx, what, this.starUrl(id), nil)
+    if err != nil {
+        return err
+    }
+    
+    defer resp.Body.Close()
+    
+    /* 204 - No Content */
+    if resp.StatusCode != 204 {
+        return newApiError(resp)
+    }
+    
+    return nil
+}
+
+func (this *GistAPI) starUrl(id string) string {
+    return fmt.Sprintf("%s/gists/%s/star", this.apiUrl, this.ensureIsGistId(id))
+}
@@ -0,0 +1,1091 @@
+/*
+ * Copyright (C) 2014  Steffen Nüssle
+ * ggist - go gist
+ *
+ * This file is part of ggist.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+
+/*
+ * Package gisttest provides an in-memory stand-in for the gist endpoints
+ * of the GitHub API. It allows to exercise gist.GistAPI end to end
+ * without network access:
+ *
+ *     server := gisttest.NewServer()
+ *     server.AddUser("token", "octocat")
+ *     server.Start("")
+ *     defer server.Close()
+ *
+ *     api := gist.NewGistAPI(gist.WithBaseUrl(server.Url()), 
+ *                            gist.WithToken("token"))
+ */
+package gisttest
+
+import (
+    "crypto/sha1"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "gist"
+    "net"
+    "net/http"
+    "net/url"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+const (
+    DefaultRateLimit = 5000
+    DefaultPerPage   = 30
+    MaxPerPage       = 100
+)
+
+const docUrl = "https://docs.github.com/rest/gists"
+
+type Server struct {
+    mutex sync.Mutex
+    url string
+    listener net.Listener
+    server *http.Server
+    users map[string]*gist.User
+    gists map[string]*fakeGist
+    nextId int64
+    nextCommentId int64
+    rateLimit int
+    used int
+    reset time.Time
+}
+
+type fakeRevision struct {
+    version string
+    user *gist.User
+    committedAt time.Time
+    files map[string]string
+    additions int
+    deletions int
+}
+
+type fakeGist struct {
+    id string
+    owner *gist.User
+    description string
+    public bool
+    createdAt time.Time
+    updatedAt time.Time
+    files map[string]string
+    revisions []*fakeRevision
+    forks []string
+    stars map[string]bool
+    comments []*gist.Comment
+}
+
+type fileChange struct {
+    Content *string                 `json:"content"`
+    FileName *string                `json:"filename"`
+}
+
+type gistChange struct {
+    Description *string             `json:"description"`
+    Public *bool                    `json:"public"`
+    Files map[string]*fileChange    `json:"files"`
+}
+
+type commentChange struct {
+    Body string                     `json:"body"`
+}
+
+type apiError struct {
+    Message string                  `json:"message"`
+    DocumentationUrl string         `json:"documentation_url"`
+    Errors []gist.FieldError        `json:"errors,omitempty"`
+}
+
+/*
+ * Create a server without any users or gists. Use Start() to listen on
+ * an address or use the server as http.Handler directly.
+ */
+func NewServer() *Server {
+    server := Server{}
+    server.users     = make(map[string]*gist.User)
+    server.gists     = make(map[string]*fakeGist)
+    server.rateLimit = DefaultRateLimit
+    
+    return &server
+}
+
+/*
+ * Requests sending token are made on behalf of the user login. Requests
+ * with unknown tokens are rejected with "401 Unauthorized".
+ */
+func (this *Server) AddUser(token string, login string) {
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+    
+    id := int64(len(this.users) + 1)
+    
+    this.users[token] = &gist.User{
+        Login : login, 
+        Id    : id, 
+        Url   : this.url + "/" + login, 
+        Type  : "User", 
+    }
+}
+
+/*
+ * Set the number of requests allowed per hour. Exceeding it results in
+ * "403 Forbidden" answers until the limit resets.
+ */
+func (this *Server) SetRateLimit(limit int) {
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+    
+    this.rateLimit = limit
+    this.used      = 0
+    this.reset     = time.Time{}
+}
+
+/*
+ * Listen on addr and serve requests in the background. An empty addr
+ * selects a free port on the loopback interface.
+ */
+func (this *Server) Start(addr string) error {
+    if len(addr) == 0 {
+        addr = "127.0.0.1:0"
+    }
+    
+    listener, err := net.Listen("tcp", addr)
+    if err != nil {
+        return errors.New("net.Listen(): " + err.Error())
+    }
+    
+    host, port, _ := net.SplitHostPort(listener.Addr().String())
+    
+    if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+        host = "127.0.0.1"
+    }
+    
+    this.mutex.Lock()
+    this.url      = "http://" + net.JoinHostPort(host, port)
+    this.listener = listener
+    this.server   = &http.Server{Handler: this}
+    this.mutex.Unlock()
+    
+    go this.server.Serve(listener)
+    
+    return nil
+}
+
+/*
+ * The base url to pass to gist.WithBaseUrl(). Only valid after Start().
+ */
+func (this *Server) Url() string {
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+    
+    return this.url
+}
+
+func (this *Server) Close() error {
+    if this.server == nil {
+        return nil
+    }
+    
+    return this.server.Close()
+}
+
+func (this *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+    
+    if len(this.url) == 0 {
+        this.url = "http://" + r.Host
+    }
+    
+    parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+    
+    /* Querying the rate limit does not count against it */
+    if r.URL.Path == "/rate_limit" {
+        this.serveRateLimit(w)
+        return
+    }
+    
+    if !this.charge() {
+        msg := "API rate limit exceeded"
+        this.reply(w, 403, &apiError{msg, docUrl, nil})
+        return
+    }
+    
+    user, ok := this.authenticate(r)
+    if !ok {
+        this.reply(w, 401, &apiError{"Bad credentials", docUrl, nil})
+        return
+    }
+    
+    switch {
+    case len(parts) == 1 && parts[0] == "user":
+        if user == nil {
+            this.requireAuthentication(w)
+            return
+        }
+        
+        this.reply(w, 200, user)
+    case len(parts) == 1 && parts[0] == "gists":
+        switch r.Method {
+        case "GET":
+            this.serveList(w, r, func(x *fakeGist) bool {
+                if user == nil {
+                    return x.public
+                }
+                
+                return x.owner != nil && x.owner.Login == user.Login
+            })
+        case "POST":
+            this.serveCreate(w, r, user)
+        default:
+            this.notFound(w)
+        }
+    case len(parts) == 2 && parts[0] == "gists" && parts[1] == "public":
+        this.serveList(w, r, func(x *fakeGist) bool {
+            return x.public
+        })
+    case len(parts) == 2 && parts[0] == "gists" && parts[1] == "starred":
+        if user == nil {
+            this.requireAuthentication(w)
+            return
+        }
+        
+        this.serveList(w, r, func(x *fakeGist) bool {
+            return x.stars[user.Login]
+        })
+    case len(parts) == 3 && parts[0] == "users" && parts[2] == "gists":
+        this.serveList(w, r, func(x *fakeGist) bool {
+            return x.public && x.owner != nil && x.owner.Login == parts[1]
+        })
+    case len(parts) == 4 && parts[0] == "raw":
+        this.serveRaw(w, parts[1], parts[2], parts[3])
+    case len(parts) >= 2 && parts[0] == "gists":
+        fake, ok := this.gists[parts[1]]
+        if !ok {
+            this.notFound(w)
+            return
+        }
+        
+        this.serveGist(w, r, user, fake, parts[2:])
+    default:
+        this.notFound(w)
+    }
+}
+
+func (this *Server) serveGist(w http.ResponseWriter, 
+                              r *http.Request, 
+                              user *gist.User, 
+                              fake *fakeGist, 
+                              parts []string) {
+    switch {
+    case len(parts) == 0 && r.Method == "GET":
+        this.serveGet(w, r, fake, fake.revisions[0])
+    case len(parts) == 0 && r.Method == "PATCH":
+        this.serveUpdate(w, r, user, fake)
+    case len(parts) == 0 && r.Method == "DELETE":
+        if !isOwner(user, fake) {
+            this.notFound(w)
+            return
+        }
+        
+        delete(this.gists, fake.id)
+        this.reply(w, 204, nil)
+    case len(parts) == 1 && parts[0] == "commits":
+        items := make([]interface{}, 0, len(fake.revisions))
+        for _, x := range fake.revisions {
+            items = append(items, this.revisionJson(fake, x))
+        }
+        
+        this.servePage(w, r, items)
+    case len(parts) == 1 && parts[0] == "star":
+        this.serveStar(w, r, user, fake)
+    case len(parts) == 1 && parts[0] == "forks":
+        this.serveForks(w, r, user, fake)
+    case len(parts) >= 1 && parts[0] == "comments":
+        this.serveComments(w, r, user, fake, parts[1:])
+    case len(parts) == 1 && r.Method == "GET":
+        for _, x := range fake.revisions {
+            if x.version == parts[0] {
+                this.serveGet(w, r, fake, x)
+                return
+            }
+        }
+        
+        this.notFound(w)
+    default:
+        this.notFound(w)
+    }
+}
+
+/*
+ * Gists carry an ETag. Conditional requests which match it are answered
+ * with "304 Not Modified" and do not count against the rate limit.
+ */
+func (this *Server) serveGet(w http.ResponseWriter, 
+                             r *http.Request, 
+                             fake *fakeGist, 
+                             revision *fakeRevision) {
+    etag := fmt.Sprintf("\"%s-%d\"", revision.version, len(fake.comments))
+    
+    w.Header().Set("ETag", etag)
+    
+    if r.Header.Get("If-None-Match") == etag {
+        this.used -= 1
+        this.reply(w, 304, nil)
+        return
+    }
+    
+    this.reply(w, 200, this.gistJson(fake, revision))
+}
+
+func (this *Server) serveCreate(w http.ResponseWriter, 
+                                r *http.Request, 
+                                user *gist.User) {
+    if user == nil {
+        this.requireAuthentication(w)
+        return
+    }
+    
+    change := gistChange{}
+    
+    if !this.decode(w, r, &change) {
+        return
+    }
+    
+    files := make(map[string]string, len(change.Files))
+    
+    for key, val := range change.Files {
+        if val == nil || val.Content == nil || len(*val.Content) == 0 {
+            this.validationFailed(w, "files", "missing_field")
+            return
+        }
+        
+        files[key] = *val.Content
+    }
+    
+    if len(files) == 0 {
+        this.validationFailed(w, "files", "missing_field")
+        return
+    }
+    
+    fake := this.newGist(user, files)
+    
+    if change.Description != nil {
+        fake.description = *change.Description
+    }
+    
+    if change.Public != nil {
+        fake.public = *change.Public
+    }
+    
+    w.Header().Set("Location", this.url + "/gists/" + fake.id)
+    
+    this.reply(w, 201, this.gistJson(fake, fake.revisions[0]))
+}
+
+/*
+ * Files mapped to null are deleted, files with a "filename" are renamed
+ * and all others are added or replaced. Every update adds a revision.
+ */
+func (this *Server) serveUpdate(w http.ResponseWriter, 
+                                r *http.Request, 
+                                user *gist.User, 
+                                fake *fakeGist) {
+    if !isOwner(user, fake) {
+        this.notFound(w)
+        return
+    }
+    
+    change := gistChange{}
+    
+    if !this.decode(w, r, &change) {
+        return
+    }
+    
+    files := make(map[string]string, len(fake.files))
+    for key, val := range fake.files {
+        files[key] = val
+    }
+    
+    names := make([]string, 0, len(change.Files))
+    for key := range change.Files {
+        names = append(names, key)
+    }
+    
+    sort.Strings(names)
+    
+    for _, x := range names {
+        val := change.Files[x]
+        
+        content, exists := files[x]
+        
+        switch {
+        case val == nil:
+            if !exists {
+                this.validationFailed(w, "files", "missing")
+                return
+            }
+            
+            delete(files, x)
+        case val.FileName != nil:
+            if !exists {
+                this.validationFailed(w, "files", "missing")
+                return
+            }
+            
+            if val.Content != nil {
+                content = *val.Content
+            }
+            
+            delete(files, x)
+            files[*val.FileName] = content
+        case val.Content != nil:
+            files[x] = *val.Content
+        }
+    }
+    
+    if len(files) == 0 {
+        this.validationFailed(w, "files", "missing_field")
+        return
+    }
+    
+    if change.Description != nil {
+        fake.description = *change.Description
+    }
+    
+    fake.addRevision(user, files)
+    
+    this.reply(w, 200, this.gistJson(fake, fake.revisions[0]))
+}
+
+func (this *Server) serveStar(w http.ResponseWriter, 
+                              r *http.Request, 
+                              user *gist.User, 
+                              fake *fakeGist) {
+    if user == nil {
+        this.requireAuthentication(w)
+        return
+    }
+    
+    switch r.Method {
+    case "GET":
+        if !fake.stars[user.Login] {
+            this.notFound(w)
+            return
+        }
+    case "PUT":
+        fake.stars[user.Login] = true
+    case "DELETE":
+        delete(fake.stars, user.Login)
+    default:
+        this.notFound(w)
+        return
+    }
+    
+    this.reply(w, 204, nil)
+}
+
+func (this *Server) serveForks(w http.ResponseWriter, 
+                               r *http.Request, 
+                               user *gist.User, 
+                               fake *fakeGist) {
+    switch r.Method {
+    case "GET":
+        items := make([]interface{}, 0, len(fake.forks))
+        for _, x := range fake.forks {
+            if fork, ok := this.gists[x]; ok {
+                items = append(items, 
+                               this.gistJson(fork, fork.revisions[0]))
+            }
+        }
+        
+        this.servePage(w, r, items)
+    case "POST":
+        if user == nil {
+            this.requireAuthentication(w)
+            return
+        }
+        
+        fork := this.newGist(user, fake.files)
+        fork.description = fake.description
+        fork.public      = fake.public
+        
+        fake.forks = append(fake.forks, fork.id)
+        
+        this.reply(w, 201, this.gistJson(fork, fork.revisions[0]))
+    default:
+        this.notFound(w)
+    }
+}
+
+func (this *Server) serveComments(w http.ResponseWriter, 
+                                  r *http.Request, 
+                                  user *gist.User, 
+                                  fake *fakeGist, 
+                                  parts []string) {
+    if len(parts) == 0 && r.Method == "GET" {
+        items := make([]interface{}, 0, len(fake.comments))
+        for _, x := range fake.comments {
+            items = append(items, x)
+        }
+        
+        this.servePage(w, r, items)
+        return
+    }
+    
+    if user == nil {
+        this.requireAuthentication(w)
+        return
+    }
+    
+    if len(parts) == 0 && r.Method == "POST" {
+        change := commentChange{}
+        
+        if !this.decode(w, r, &change) {
+            return
+        }
+        
+        if len(change.Body) == 0 {
+            this.validationFailed(w, "body", "missing_field")
+            return
+        }
+        
+        this.nextCommentId += 1
+        
+        now := time.Now().UTC().Truncate(time.Second)
+        
+        url := fmt.Sprintf("%s/gists/%s/comments/%d", 
+                           this.url, fake.id, this.nextCommentId)
+        
+        comment := &gist.Comment{
+            Id        : this.nextCommentId, 
+            ApiUrl    : url, 
+            Body      : change.Body, 
+            User      : user, 
+            CreatedAt : now, 
+            UpdatedAt : now, 
+        }
+        
+        fake.comments = append(fake.comments, comment)
+        
+        this.reply(w, 201, comment)
+        return
+    }
+    
+    id, err := strconv.ParseInt(parts[0], 10, 64)
+    if err != nil || len(parts) != 1 {
+        this.notFound(w)
+        return
+    }
+    
+    for i, x := range fake.comments {
+        if x.Id != id || x.User.Login != user.Login {
+            continue
+        }
+        
+        switch r.Method {
+        case "GET":
+            this.reply(w, 200, x)
+        case "PATCH":
+            change := commentChange{}
+            
+            if !this.decode(w, r, &change) {
+                return
+            }
+            
+            if len(change.Body) == 0 {
+                this.validationFailed(w, "body", "missing_field")
+                return
+            }
+            
+            x.Body      = change.Body
+            x.UpdatedAt = time.Now().UTC().Truncate(time.Second)
+            
+            this.reply(w, 200, x)
+        case "DELETE":
+            fake.comments = append(fake.comments[:i], fake.comments[i + 1:]...)
+            
+            this.reply(w, 204, nil)
+        default:
+            this.notFound(w)
+        }
+        
+        return
+    }
+    
+    this.notFound(w)
+}
+
+func (this *Server) serveRaw(w http.ResponseWriter, 
+                             id string, 
+                             version string, 
+                             name string) {
+    fake, ok := this.gists[id]
+    if !ok {
+        this.notFound(w)
+        return
+    }
+    
+    for _, x := range fake.revisions {
+        content, ok := x.files[name]
+        
+        if x.version == version && ok {
+            w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+            this.setRateLimitHeaders(w.Header())
+            w.WriteHeader(200)
+            w.Write([]byte(content))
+            return
+        }
+    }
+    
+    this.notFound(w)
+}
+
+/*
+ * List the gists accepted by filter, most recently updated first, and
+ * honour the "since" parameter.
+ */
+func (this *Server) serveList(w http.ResponseWriter, 
+                              r *http.Request, 
+                              filter func(*fakeGist) bool) {
+    since := time.Time{}
+    
+    if s := r.URL.Query().Get("since"); len(s) > 0 {
+        t, err := time.Parse(time.RFC3339, s)
+        if err != nil {
+            this.validationFailed(w, "since", "invalid")
+            return
+        }
+        
+        since = t
+    }
+    
+    list := make([]*fakeGist, 0, len(this.gists))
+    
+    for _, x := range this.gists {
+        if filter(x) && !x.updatedAt.Before(since) {
+            list = append(list, x)
+        }
+    }
+    
+    sort.Slice(list, func(i, j int) bool {
+        if !list[i].updatedAt.Equal(list[j].updatedAt) {
+            return list[i].updatedAt.After(list[j].updatedAt)
+        }
+        
+        return list[i].id > list[j].id
+    })
+    
+    items := make([]interface{}, 0, len(list))
+    for _, x := range list {
+        items = append(items, this.gistJson(x, x.revisions[0]))
+    }
+    
+    this.servePage(w, r, items)
+}
+
+/*
+ * Serve the page selected by the "page" and "per_page" parameters and
+ * link to the next and last page like GitHub does.
+ */
+func (this *Server) servePage(w http.ResponseWriter, 
+                              r *http.Request, 
+                              items []interface{}) {
+    query := r.URL.Query()
+    
+    page, err := strconv.Atoi(query.Get("page"))
+    if err != nil || page < 1 {
+        page = 1
+    }
+    
+    perPage, err := strconv.Atoi(query.Get("per_page"))
+    if err != nil || perPage < 1 {
+        perPage = DefaultPerPage
+    }
+    
+    if perPage > MaxPerPage {
+        perPage = MaxPerPage
+    }
+    
+    last := (len(items) + perPage - 1) / perPage
+    
+    begin := (page - 1) * perPage
+    end := begin + perPage
+    
+    if begin > len(items) {
+        begin = len(items)
+    }
+    
+    if end > len(items) {
+        end = len(items)
+    }
+    
+    if page < last {
+        links := []string{
+            this.pageLink(r.URL, query, page + 1, "next"),
+            this.pageLink(r.URL, query, last, "last"),
+        }
+        
+        w.Header().Set("Link", strings.Join(links, ", "))
+    }
+    
+    this.reply(w, 200, items[begin:end])
+}
+
+func (this *Server) pageLink(u *url.URL, 
+                             query url.Values, 
+                             page int, 
+                             rel string) string {
+    query.Set("page", strconv.Itoa(page))
+    
+    link := this.url + u.Path + "?" + query.Encode()
+    
+    return fmt.Sprintf("<%s>; rel=\"%s\"", link, rel)
+}
+
+func (this *Server) serveRateLimit(w http.ResponseWriter) {
+    this.refreshRateLimit()
+    
+    core := map[string]int64{
+        "limit"     : int64(this.rateLimit),
+        "remaining" : int64(this.remaining()),
+        "used"      : int64(this.used),
+        "reset"     : this.reset.Unix(),
+    }
+    
+    data := map[string]interface{}{
+        "resources" : map[string]interface{}{ "core" : core },
+        "rate"      : core,
+    }
+    
+    this.reply(w, 200, data)
+}
+
+/*
+ * Count a request against the rate limit. Returns false if the limit
+ * is exhausted.
+ */
+func (this *Server) charge() bool {
+    this.refreshRateLimit()
+    
+    if this.used >= this.rateLimit {
+        return false
+    }
+    
+    this.used += 1
+    
+    return true
+}
+
+func (this *Server) refreshRateLimit() {
+    now := time.Now()
+    
+    if now.After(this.reset) {
+        this.used  = 0
+        this.reset = now.Add(time.Hour).Truncate(time.Second)
+    }
+}
+
+func (this *Server) remaining() int {
+    if this.used >= this.rateLimit {
+        return 0
+    }
+    
+    return this.rateLimit - this.used
+}
+
+func (this *Server) setRateLimitHeaders(header http.Header) {
+    header.Set("X-RateLimit-Limit", strconv.Itoa(this.rateLimit))
+    header.Set("X-RateLimit-Remaining", strconv.Itoa(this.remaining()))
+    header.Set("X-RateLimit-Used", strconv.Itoa(this.used))
+    header.Set("X-RateLimit-Reset", strconv.FormatInt(this.reset.Unix(), 10))
+}
+
+/*
+ * Returns the user owning the token of the request or nil for anonymous
+ * requests. The second result is false for unknown tokens.
+ */
+func (this *Server) authenticate(r *http.Request) (*gist.User, bool) {
+    auth := r.Header.Get("Authorization")
+    if len(auth) == 0 {
+        return nil, true
+    }
+    
+    fields := strings.Fields(auth)
+    if len(fields) != 2 {
+        return nil, false
+    }
+    
+    user, ok := this.users[fields[1]]
+    
+    return user, ok
+}
+
+func (this *Server) decode(w http.ResponseWriter, 
+                           r *http.Request, 
+                           v interface{}) bool {
+    err := json.NewDecoder(r.Body).Decode(v)
+    if err != nil {
+        this.reply(w, 400, &apiError{"Problems parsing JSON", docUrl, nil})
+        return false
+    }
+    
+    return true
+}
+
+func (this *Server) reply(w http.ResponseWriter, 
+                          status int, 
+                          v interface{}) {
+    this.setRateLimitHeaders(w.Header())
+    
+    if v == nil {
+        w.WriteHeader(status)
+        return
+    }
+    
+    data, err := json.Marshal(v)
+    if err != nil {
+        w.WriteHeader(500)
+        return
+    }
+    
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    w.WriteHeader(status)
+    w.Write(data)
+}
+
+func (this *Server) notFound(w http.ResponseWriter) {
+    this.reply(w, 404, &apiError{"Not Found", docUrl, nil})
+}
+
+func (this *Server) requireAuthentication(w http.ResponseWriter) {
+    this.reply(w, 401, &apiError{"Requires authentication", docUrl, nil})
+}
+
+func (this *Server) validationFailed(w http.ResponseWriter, 
+                                     field string, 
+                                     code string) {
+    fieldErrors := []gist.FieldError{
+        gist.FieldError{ Resource : "Gist", Field : field, Code : code }, 
+    }
+    
+    this.reply(w, 422, &apiError{"Validation Failed", docUrl, fieldErrors})
+}
+
+/*
+ * Ids are 32 hexadecimal digits like the ones of GitHub but increase
+ * monotonically, so tests can predict them. The "fa" prefix keeps them
+ * from being mistaken for history indices.
+ */
+func (this *Server) newGist(user *gist.User, 
+                            files map[string]string) *fakeGist {
+    this.nextId += 1
+    
+    fake := fakeGist{}
+    fake.id        = fmt.Sprintf("fa%030x", this.nextId)
+    fake.owner     = user
+    fake.createdAt = time.Now().UTC().Truncate(time.Second)
+    fake.stars     = make(map[string]bool)
+    
+    fake.addRevision(user, files)
+    
+    this.gists[fake.id] = &fake
+    
+    return &fake
+}
+
+func (this *fakeGist) addRevision(user *gist.User, 
+                                  files map[string]string) {
+    now := time.Now().UTC().Truncate(time.Second)
+    
+    old := map[string]string{}
+    if len(this.revisions) > 0 {
+        old = this.revisions[0].files
+    }
+    
+    hash := sha1.New()
+    fmt.Fprintf(hash, "%s %d", this.id, len(this.revisions))
+    
+    names := make([]string, 0, len(files))
+    for key := range files {
+        names = append(names, key)
+    }
+    
+    sort.Strings(names)
+    
+    for _, x := range names {
+        fmt.Fprintf(hash, "\x00%s\x00%s", x, files[x])
+    }
+    
+    revision := fakeRevision{}
+    revision.version     = hex.EncodeToString(hash.Sum(nil))
+    revision.user        = user
+    revision.committedAt = now
+    revision.files       = files
+    
+    revision.additions, revision.deletions = countChanges(old, files)
+    
+    this.revisions = append([]*fakeRevision{ &revision }, this.revisions...)
+    this.files     = files
+    this.updatedAt = now
+}
+
+func (this *Server) gistJson(fake *fakeGist, 
+                             revision *fakeRevision) *gist.Gist {
+    ret := gist.Gist{}
+    ret.Url         = this.url + "/" + fake.id
+    ret.Id          = fake.id
+    ret.Description = fake.description
+    ret.Files       = make(map[string]*gist.GistFile, len(revision.files))
+    ret.Public      = fake.public
+    ret.ApiUrl      = this.url + "/gists/" + fake.id
+    ret.Owner       = fake.owner
+    ret.CreatedAt   = fake.createdAt
+    ret.UpdatedAt   = fake.updatedAt
+    ret.Comments    = len(fake.comments)
+    ret.CommentsUrl = ret.ApiUrl + "/comments"
+    ret.GitPullUrl  = this.url + "/" + fake.id + ".git"
+    ret.GitPushUrl  = ret.GitPullUrl
+    ret.Forks       = make([]gist.GistFork, 0, len(fake.forks))
+    ret.History     = make([]gist.GistRevision, 0, len(fake.revisions))
+    
+    for key, val := range revision.files {
+        rawUrl := fmt.Sprintf("%s/raw/%s/%s/%s", 
+                              this.url, fake.id, revision.version, key)
+        
+        ret.Files[key] = &gist.GistFile{
+            FileName : key, 
+            Type     : "text/plain", 
+            Size     : int64(len(val)), 
+            Content  : val, 
+            RawUrl   : rawUrl, 
+        }
+    }
+    
+    for _, x := range fake.forks {
+        if fork, ok := this.gists[x]; ok {
+            ret.Forks = append(ret.Forks, *this.forkJson(fork))
+        }
+    }
+    
+    for _, x := range fake.revisions {
+        ret.History = append(ret.History, *this.revisionJson(fake, x))
+    }
+    
+    return &ret
+}
+
+func (this *Server) forkJson(fork *fakeGist) *gist.GistFork {
+    return &gist.GistFork{
+        Id        : fork.id, 
+        ApiUrl    : this.url + "/gists/" + fork.id, 
+        User      : fork.owner, 
+        CreatedAt : fork.createdAt, 
+        UpdatedAt : fork.updatedAt, 
+    }
+}
+
+func (this *Server) revisionJson(fake *fakeGist, 
+                                 revision *fakeRevision) *gist.GistRevision {
+    url := fmt.Sprintf("%s/gists/%s/%s", this.url, fake.id, revision.version)
+    
+    total := revision.additions + revision.deletions
+    
+    status := gist.ChangeStatus{
+        Total     : total, 
+        Additions : revision.additions, 
+        Deletions : revision.deletions, 
+    }
+    
+    return &gist.GistRevision{
+        Version      : revision.version, 
+        ApiUrl       : url, 
+        User         : revision.user, 
+        CommittedAt  : revision.committedAt, 
+        ChangeStatus : status, 
+    }
+}
+
+func isOwner(user *gist.User, fake *fakeGist) bool {
+    return user != nil && fake.owner != nil && user.Login == fake.owner.Login
+}
+
+/*
+ * Approximate the line statistics of a revision by comparing the sets
+ * of lines of each file.
+ */
+func countChanges(oldFiles map[string]string, 
+                  newFiles map[string]string) (int, int) {
+    additions := 0
+    deletions := 0
+    
+    for key, val := range newFiles {
+        a, d := countLineChanges(oldFiles[key], val)
+        additions += a
+        deletions += d
+    }
+    
+    for key, val := range oldFiles {
+        if _, ok := newFiles[key]; !ok {
+            _, d := countLineChanges(val, "")
+            deletions += d
+        }
+    }
+    
+    return additions, deletions
+}
+
+func countLineChanges(oldContent string, newContent string) (int, int) {
+    lines := make(map[string]int)
+    
+    for _, x := range splitLines(oldContent) {
+        lines[x] += 1
+    }
+    
+    additions := 0
+    
+    for _, x := range splitLines(newContent) {
+        if lines[x] > 0 {
+            lines[x] -= 1
+        } else {
+            additions += 1
+        }
+    }
+    
+    deletions := 0
+    
+    for _, x := range lines {
+        deletions += x
+    }
+    
+    return additions, deletions
+}
+
+func splitLines(s string) []string {
+    if len(s) == 0 {
+        return nil
+    }
+    
+    return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
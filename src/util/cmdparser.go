@@ -250,8 +250,6 @@ func setOpt(opt Option,
                 return 0, err
             }
         }
-        
-        i += len(list)
     case *OptInt, *OptStr:
         if len(list) != 1 {
             msg := "Exactly one argument exspected, "
@@ -264,8 +262,6 @@ func setOpt(opt Option,
         if err != nil {
             return 0, err
         }
-        
-        i += 1
     default:
         return 0, errors.New("Invalid option type")
     }
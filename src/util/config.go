@@ -0,0 +1,92 @@
+/*
+ * Copyright (C) 2014  Steffen Nüssle
+ * ggist - go gist
+ *
+ * This file is part of ggist.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package util
+
+import (
+    "bufio"
+    "errors"
+    "fmt"
+    "os"
+    "strings"
+)
+
+/*
+ * A Config holds the settings read from a simple configuration file.
+ * Each line of the file contains a single "key = value" pair; empty lines
+ * and lines starting with '#' are ignored.
+ */
+type Config struct {
+    path string
+    values map[string]string
+}
+
+func NewConfig(path string) (*Config, error) {
+    config := Config{path, make(map[string]string)}
+    
+    file, err := os.Open(path)
+    if err != nil {
+        if os.IsNotExist(err) {
+            return &config, nil
+        }
+        
+        return nil, err
+    }
+    
+    defer file.Close()
+    
+    scanner := bufio.NewScanner(file)
+    
+    for n := 1; scanner.Scan(); n++ {
+        line := strings.TrimSpace(scanner.Text())
+        
+        if len(line) == 0 || line[0] == '#' {
+            continue
+        }
+        
+        index := strings.Index(line, "=")
+        if index <= 0 {
+            msg := fmt.Sprintf("%s:%d: expected \"key = value\"", path, n)
+            return nil, errors.New(msg)
+        }
+        
+        key := strings.TrimSpace(line[:index])
+        val := strings.TrimSpace(line[index + 1:])
+        
+        config.values[key] = val
+    }
+    
+    err = scanner.Err()
+    if err != nil {
+        return nil, err
+    }
+    
+    return &config, nil
+}
+
+func (this *Config) Get(key string) string {
+    return this.values[key]
+}
+
+func (this *Config) Has(key string) bool {
+    _, ok := this.values[key]
+    
+    return ok
+}
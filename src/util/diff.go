@@ -0,0 +1,290 @@
+/*
+ * Copyright (C) 2014  Steffen Nüssle
+ * ggist - go gist
+ *
+ * This file is part of ggist.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package util
+
+import (
+    "fmt"
+    "strings"
+)
+
+/*
+ * Edit scripts longer than this are not searched for, instead the part
+ * between the common prefix and suffix is replaced as a whole. This
+ * bounds the memory needed for the trace of the search.
+ */
+const maxEditDistance = 2000
+
+type diffOp struct {
+    kind byte
+    text string
+}
+
+/*
+ * Return the differences between a and b in the unified diff format with
+ * the given number of context lines. An empty string is returned if both
+ * texts are equal.
+ */
+func UnifiedDiff(oldName string, 
+                 newName string, 
+                 a string, 
+                 b string, 
+                 context int) string {
+    ops := diffLines(splitLines(a), splitLines(b))
+    
+    changes := make([]int, 0, len(ops))
+    
+    for i, x := range ops {
+        if x.kind != ' ' {
+            changes = append(changes, i)
+        }
+    }
+    
+    if len(changes) == 0 {
+        return ""
+    }
+    
+    ret := fmt.Sprintf("--- %s\n+++ %s\n", oldName, newName)
+    
+    for i := 0; i < len(changes); {
+        /* Changes which share their context end up in the same hunk */
+        j := i
+        for j + 1 < len(changes) && 
+            changes[j + 1] - changes[j] - 1 <= 2 * context {
+            j += 1
+        }
+        
+        begin := changes[i] - context
+        if begin < 0 {
+            begin = 0
+        }
+        
+        end := changes[j] + context + 1
+        if end > len(ops) {
+            end = len(ops)
+        }
+        
+        ret += formatHunk(ops, begin, end)
+        
+        i = j + 1
+    }
+    
+    return ret
+}
+
+func formatHunk(ops []diffOp, begin int, end int) string {
+    oldLine, newLine := 1, 1
+    
+    for _, x := range ops[:begin] {
+        if x.kind != '+' {
+            oldLine += 1
+        }
+        
+        if x.kind != '-' {
+            newLine += 1
+        }
+    }
+    
+    oldCount, newCount := 0, 0
+    body := ""
+    
+    for _, x := range ops[begin:end] {
+        if x.kind != '+' {
+            oldCount += 1
+        }
+        
+        if x.kind != '-' {
+            newCount += 1
+        }
+        
+        body += string(x.kind) + x.text
+        
+        if !strings.HasSuffix(x.text, "\n") {
+            body += "\n\\ No newline at end of file\n"
+        }
+    }
+    
+    /* Empty ranges refer to the line before them */
+    if oldCount == 0 {
+        oldLine -= 1
+    }
+    
+    if newCount == 0 {
+        newLine -= 1
+    }
+    
+    header := fmt.Sprintf("@@ -%d,%d +%d,%d @@\n", 
+                          oldLine, oldCount, newLine, newCount)
+    
+    return header + body
+}
+
+/*
+ * Lines keep their newline, so a missing newline at the end of the text
+ * counts as a difference.
+ */
+func splitLines(s string) []string {
+    if len(s) == 0 {
+        return []string{}
+    }
+    
+    lines := strings.SplitAfter(s, "\n")
+    
+    if len(lines[len(lines) - 1]) == 0 {
+        lines = lines[:len(lines) - 1]
+    }
+    
+    return lines
+}
+
+/*
+ * Compute a shortest edit script transforming a into b with the
+ * algorithm described in "An O(ND) Difference Algorithm and Its
+ * Variations" by Eugene W. Myers.
+ */
+func diffLines(a []string, b []string) []diffOp {
+    prefix := 0
+    for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+        prefix += 1
+    }
+    
+    suffix := 0
+    for suffix < len(a) - prefix && suffix < len(b) - prefix && 
+        a[len(a) - 1 - suffix] == b[len(b) - 1 - suffix] {
+        suffix += 1
+    }
+    
+    ops := make([]diffOp, 0, len(a) + len(b))
+    
+    for _, x := range a[:prefix] {
+        ops = append(ops, diffOp{' ', x})
+    }
+    
+    ops = append(ops, diffMiddle(a[prefix:len(a) - suffix], 
+                                 b[prefix:len(b) - suffix])...)
+    
+    for _, x := range a[len(a) - suffix:] {
+        ops = append(ops, diffOp{' ', x})
+    }
+    
+    return ops
+}
+
+func diffMiddle(a []string, b []string) []diffOp {
+    n, m := len(a), len(b)
+    offset := n + m + 1
+    
+    v := make([]int, 2 * offset + 1)
+    trace := make([][]int, 0, 16)
+    
+    for d := 0; d <= n + m && d <= maxEditDistance; d++ {
+        /* Step d only reads the diagonals -(d - 1) to d - 1 */
+        snapshot := v[offset - d:offset + d + 1]
+        trace = append(trace, append([]int(nil), snapshot...))
+        
+        for k := -d; k <= d; k += 2 {
+            x := 0
+            
+            if k == -d || (k != d && v[offset + k - 1] < v[offset + k + 1]) {
+                x = v[offset + k + 1]
+            } else {
+                x = v[offset + k - 1] + 1
+            }
+            
+            y := x - k
+            
+            for x < n && y < m && a[x] == b[y] {
+                x += 1
+                y += 1
+            }
+            
+            v[offset + k] = x
+            
+            if x >= n && y >= m {
+                return backtrack(a, b, trace)
+            }
+        }
+    }
+    
+    return replaceLines(a, b)
+}
+
+func replaceLines(a []string, b []string) []diffOp {
+    ops := make([]diffOp, 0, len(a) + len(b))
+    
+    for _, x := range a {
+        ops = append(ops, diffOp{'-', x})
+    }
+    
+    for _, x := range b {
+        ops = append(ops, diffOp{'+', x})
+    }
+    
+    return ops
+}
+
+/*
+ * trace[d] holds the diagonals -d to d as they were before step d.
+ */
+func backtrack(a []string, b []string, trace [][]int) []diffOp {
+    ops := make([]diffOp, 0, len(a) + len(b))
+    x, y := len(a), len(b)
+    
+    for d := len(trace) - 1; d >= 0; d-- {
+        v := trace[d]
+        offset := d
+        k := x - y
+        
+        prev, prevX, prevY := 0, 0, 0
+        
+        if d > 0 {
+            prev = k - 1
+            if k == -d || (k != d && v[offset + k - 1] < v[offset + k + 1]) {
+                prev = k + 1
+            }
+            
+            prevX = v[offset + prev]
+            prevY = prevX - prev
+        }
+        
+        for x > prevX && y > prevY {
+            ops = append(ops, diffOp{' ', a[x - 1]})
+            x -= 1
+            y -= 1
+        }
+        
+        if d > 0 {
+            if x == prevX {
+                ops = append(ops, diffOp{'+', b[y - 1]})
+            } else {
+                ops = append(ops, diffOp{'-', a[x - 1]})
+            }
+        }
+        
+        x, y = prevX, prevY
+    }
+    
+    /* The edit script was built from its end */
+    for i, j := 0, len(ops) - 1; i < j; i, j = i + 1, j - 1 {
+        ops[i], ops[j] = ops[j], ops[i]
+    }
+    
+    return ops
+}
@@ -0,0 +1,161 @@
+/*
+ * Copyright (C) 2014  Steffen Nüssle
+ * ggist - go gist
+ *
+ * This file is part of ggist.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package util
+
+import (
+    "fmt"
+    "strings"
+    "testing"
+)
+
+func numberedLines(n int, changed func(int) bool) string {
+    lines := make([]string, 0, n)
+    
+    for i := 1; i <= n; i++ {
+        if changed != nil && changed(i) {
+            lines = append(lines, fmt.Sprintf("changed %d\n", i))
+        } else {
+            lines = append(lines, fmt.Sprintf("%d\n", i))
+        }
+    }
+    
+    return strings.Join(lines, "")
+}
+
+func TestUnifiedDiff(t *testing.T) {
+    twelve := numberedLines(12, nil)
+    
+    tests := []struct {
+        name string
+        a string
+        b string
+        want string
+    }{
+        { "equal", "a\nb\n", "a\nb\n", "" }, 
+        { "insert only", "a\nb\n", "a\nx\nb\n", 
+          "@@ -1,2 +1,3 @@\n a\n+x\n b\n" }, 
+        { "delete only", "a\nb\nc\n", "a\nc\n", 
+          "@@ -1,3 +1,2 @@\n a\n-b\n c\n" }, 
+        { "from empty", "", "a\n", 
+          "@@ -0,0 +1,1 @@\n+a\n" }, 
+        { "to empty", "a\n", "", 
+          "@@ -1,1 +0,0 @@\n-a\n" }, 
+        { "newline removed", "x\n", "x", 
+          "@@ -1,1 +1,1 @@\n-x\n+x\n\\ No newline at end of file\n" }, 
+        { "newline added", "a\nx", "a\nx\n", 
+          "@@ -1,2 +1,2 @@\n a\n-x\n\\ No newline at end of file\n+x\n" }, 
+        { "unchanged last line without newline", "a\nx", "b\nx", 
+          "@@ -1,2 +1,2 @@\n-a\n+b\n x\n\\ No newline at end of file\n" }, 
+        { "shared context", twelve, 
+          numberedLines(12, func(i int) bool { return i == 2 || i == 9 }), 
+          "@@ -1,12 +1,12 @@\n" + 
+          " 1\n-2\n+changed 2\n 3\n 4\n 5\n 6\n 7\n 8\n" + 
+          "-9\n+changed 9\n 10\n 11\n 12\n" }, 
+        { "separate hunks", twelve, 
+          numberedLines(12, func(i int) bool { return i == 2 || i == 10 }), 
+          "@@ -1,5 +1,5 @@\n 1\n-2\n+changed 2\n 3\n 4\n 5\n" + 
+          "@@ -7,6 +7,6 @@\n 7\n 8\n 9\n-10\n+changed 10\n 11\n 12\n" }, 
+    }
+    
+    for _, x := range tests {
+        want := x.want
+        if len(want) > 0 {
+            want = "--- a\n+++ b\n" + want
+        }
+        
+        got := UnifiedDiff("a", "b", x.a, x.b, 3)
+        if got != want {
+            t.Errorf("%s: got\n%s\nwant\n%s", x.name, got, want)
+        }
+    }
+}
+
+/*
+ * Apply the edit script to get back both texts.
+ */
+func applyOps(ops []diffOp) (string, string) {
+    a, b := "", ""
+    
+    for _, x := range ops {
+        if x.kind != '+' {
+            a += x.text
+        }
+        
+        if x.kind != '-' {
+            b += x.text
+        }
+    }
+    
+    return a, b
+}
+
+func countOps(ops []diffOp, kind byte) int {
+    n := 0
+    
+    for _, x := range ops {
+        if x.kind == kind {
+            n += 1
+        }
+    }
+    
+    return n
+}
+
+func TestDiffLinesShortestScript(t *testing.T) {
+    a := numberedLines(100, nil)
+    b := numberedLines(100, func(i int) bool { return i % 10 == 0 })
+    
+    ops := diffLines(splitLines(a), splitLines(b))
+    
+    if x, y := applyOps(ops); x != a || y != b {
+        t.Fatalf("Edit script does not reproduce the texts")
+    }
+    
+    if countOps(ops, ' ') != 90 || countOps(ops, '-') != 10 {
+        t.Errorf("Edit script keeps %d lines and removes %d, want 90 and 10", 
+                 countOps(ops, ' '), countOps(ops, '-'))
+    }
+}
+
+func TestDiffLinesFallback(t *testing.T) {
+    n := maxEditDistance + 1001
+    
+    /* Every other line changes, which exceeds the edit distance limit */
+    a := numberedLines(n, nil)
+    b := numberedLines(n, func(i int) bool { return i % 2 == 0 })
+    
+    ops := diffLines(splitLines(a), splitLines(b))
+    
+    if x, y := applyOps(ops); x != a || y != b {
+        t.Fatalf("Edit script does not reproduce the texts")
+    }
+    
+    /* Only the common first and last line are kept */
+    if countOps(ops, ' ') != 2 {
+        t.Errorf("Edit script keeps %d lines, want 2", countOps(ops, ' '))
+    }
+    
+    for i := 1; i < n - 1; i++ {
+        if ops[i].kind != '-' || ops[i + n - 2].kind != '+' {
+            t.Fatalf("Middle part is not replaced as a whole at line %d", i)
+        }
+    }
+}
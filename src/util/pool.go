@@ -0,0 +1,76 @@
+/*
+ * Copyright (C) 2014  Steffen Nüssle
+ * ggist - go gist
+ *
+ * This file is part of ggist.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package util
+
+/*
+ * A Task does its work in the background and returns a function which
+ * presents the result. That function is always run on the calling
+ * goroutine of RunOrdered.
+ */
+type Task func() (func(), error)
+
+type taskResult struct {
+    done func()
+    err error
+}
+
+/*
+ * Run the tasks with at most jobs of them in parallel. Results are
+ * presented in the order of tasks, errors are passed to report at the
+ * position of the failed task.
+ */
+func RunOrdered(jobs int, tasks []Task, report func(error)) {
+    if jobs < 1 {
+        jobs = 1
+    }
+    
+    results := make([]chan taskResult, len(tasks))
+    
+    for i, _ := range results {
+        results[i] = make(chan taskResult, 1)
+    }
+    
+    go func() {
+        sem := make(chan bool, jobs)
+        
+        for i, x := range tasks {
+            sem <- true
+            
+            go func(i int, task Task) {
+                defer func() { <-sem }()
+                
+                done, err := task()
+                
+                results[i] <- taskResult{done, err}
+            }(i, x)
+        }
+    }()
+    
+    for _, x := range results {
+        result := <-x
+        
+        if result.err != nil {
+            report(result.err)
+        } else if result.done != nil {
+            result.done()
+        }
+    }
+}